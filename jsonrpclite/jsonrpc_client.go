@@ -1,5 +1,11 @@
 package jsonrpclite
 
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
 type rpcClient struct {
 	engine RpcClientEngine
 }
@@ -14,6 +20,85 @@ func (client *rpcClient) SendData(serviceName string, method string, params []an
 	return client.engine.ProcessData(serviceName, method, params)
 }
 
+// Call Send the request and unmarshal the result into result, a *RpcError is returned if the server responds with an error.
+func (client *rpcClient) Call(ctx context.Context, serviceName string, method string, params []any, result any) error {
+	requestData, err := encodeRequestData(nextRequestId(), method, params)
+	if err != nil {
+		return errors.New("Encode request error: " + err.Error())
+	}
+	response, err := client.processString(ctx, serviceName, string(requestData))
+	if err != nil {
+		return err
+	}
+	return decodeResponseString(response, result)
+}
+
+// Notify Send the notification to the server, no response will be returned.
+func (client *rpcClient) Notify(ctx context.Context, serviceName string, method string, params []any) error {
+	requestData, err := encodeRequestData(nil, method, params)
+	if err != nil {
+		return errors.New("Encode request error: " + err.Error())
+	}
+	_, err = client.processString(ctx, serviceName, string(requestData))
+	return err
+}
+
+// processString Send the request string by the engine, the engine which is not a RpcContextClientEngine ignores the
+// ctx and its panic is returned as error.
+func (client *rpcClient) processString(ctx context.Context, serviceName string, requestStr string) (response string, err error) {
+	engine, ok := client.engine.(RpcContextClientEngine)
+	if ok {
+		return engine.ProcessStringContext(ctx, serviceName, requestStr)
+	}
+	defer func() {
+		var p = any(recover())
+		if p != nil {
+			err = errors.New(fmt.Sprintf("%v", p))
+		}
+	}()
+	return client.engine.ProcessString(serviceName, requestStr), nil
+}
+
+// OnNotification Register the handler of the notification method pushed by the server, the handler is a func which
+// can take a context.Context first and return nothing or an error, the other params are decoded from the notification.
+// It panics if the engine does not keep persistent connections.
+func (client *rpcClient) OnNotification(method string, handler any) {
+	client.notificationEngine().OnNotification(method, handler)
+}
+
+// notificationEngine Get the engine as RpcNotificationEngine, panic if it does not keep persistent connections.
+func (client *rpcClient) notificationEngine() RpcNotificationEngine {
+	engine, ok := client.engine.(RpcNotificationEngine)
+	if !ok {
+		var err any = errors.New("The engine " + client.engine.GetName() + " can not receive notifications.")
+		panic(err)
+	}
+	return engine
+}
+
+// Subscribe Call the subscription method of the service, the events are passed to the handler which is a func taking
+// an optional context.Context and the event, returns the subscription id. It panics if the engine does not keep
+// persistent connections.
+func (client *rpcClient) Subscribe(ctx context.Context, serviceName string, method string, params []any, handler any) (string, error) {
+	return client.notificationEngine().Subscribe(ctx, serviceName, method, params, handler)
+}
+
+// Unsubscribe Cancel the subscription made by Subscribe.
+func (client *rpcClient) Unsubscribe(ctx context.Context, serviceName string, subscriptionId string) error {
+	return client.notificationEngine().Unsubscribe(ctx, serviceName, subscriptionId)
+}
+
+// Stream Call the method which returns a channel, the elements are passed to the handler in order. The handler is
+// a func taking an optional context.Context and the element. If the engine can not stream, the elements are collected
+// by the server and passed to the handler after the response.
+func (client *rpcClient) Stream(ctx context.Context, serviceName string, method string, params []any, handler any) error {
+	engine, ok := client.engine.(RpcStreamEngine)
+	if !ok {
+		return collectStream(ctx, client, serviceName, method, params, handler)
+	}
+	return engine.Stream(ctx, serviceName, method, params, handler)
+}
+
 //Close the client if needed
 func (client *rpcClient) Close() {
 	client.engine.Close()
@@ -25,3 +110,10 @@ func NewRpcClient(engine RpcClientEngine) *rpcClient {
 	client.engine = engine
 	return client
 }
+
+// Invoke Call the method and return the result as T, a *RpcError is returned if the server responds with an error.
+func Invoke[T any](ctx context.Context, client *rpcClient, serviceName string, method string, params ...any) (T, error) {
+	var result T
+	err := client.Call(ctx, serviceName, method, params, &result)
+	return result, err
+}
@@ -0,0 +1,85 @@
+package jsonrpclite
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// defaultRequestTimeout The timeout of a request if the caller does not give a deadline.
+const defaultRequestTimeout = 5 * time.Second
+
+// rpcMessageConn A persistent connection which carries whole JSON-RPC messages.
+type rpcMessageConn interface {
+	// readMessage Read a whole message from the connection.
+	readMessage() ([]byte, error)
+	// writeMessage Write a whole message to the connection, it is safe to be called concurrently.
+	writeMessage(message []byte) error
+	// close Close the connection.
+	close() error
+	// remoteAddr Get the address of the other side.
+	remoteAddr() string
+}
+
+// A request which is waiting for its response on a connection.
+type rpcPendingCall struct {
+	keys     []string                             //The keys of the request ids, a batch has more than one
+	response chan string                          //Receive the response
+	hook     func(peer *RpcPeer, response string) //Called in the reading goroutine before the next message is read
+}
+
+// idKey Normalize the id into the key for matching request and response.
+func idKey(id any) string {
+	key, err := json.Marshal(id)
+	if err != nil {
+		return fmt.Sprintf("%v", id)
+	}
+	return string(key)
+}
+
+// isRequestMessage Check whether the message is a request or a batch of requests rather than responses,
+// the message which can not be parsed is treated as a request so that the parse error is answered.
+func isRequestMessage(message []byte) bool {
+	type methodData struct {
+		Method *string `json:"method"`
+	}
+	trimmed := bytes.TrimSpace(message)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var items []methodData
+		err := json.Unmarshal(trimmed, &items)
+		return err != nil || len(items) == 0 || items[0].Method != nil
+	}
+	var item methodData
+	err := json.Unmarshal(trimmed, &item)
+	return err != nil || item.Method != nil
+}
+
+// messageIdKeys Get the id keys of the message or the messages in a batch, the ids of null are skipped.
+func messageIdKeys(message []byte) ([]string, error) {
+	var items []map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(message))
+	decoder.UseNumber()
+	trimmed := strings.TrimSpace(string(message))
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		err := decoder.Decode(&items)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var item map[string]any
+		err := decoder.Decode(&item)
+		if err != nil {
+			return nil, err
+		}
+		items = []map[string]any{item}
+	}
+	keys := make([]string, 0, len(items))
+	for _, item := range items {
+		if id := item["id"]; id != nil {
+			keys = append(keys, idKey(id))
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,131 @@
+package jsonrpclite
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"time"
+)
+
+type rpcContextKey uint8
+
+const (
+	transportInfoKey         rpcContextKey = iota //Key of the RpcTransportInfo in context
+	requestInfoKey                                //Key of the RpcRequestInfo in context
+	peerKey                                       //Key of the RpcPeer in context
+	subscriptionCollectorKey                      //Key of the rpcSubscriptionCollector in context
+	responseHookKey                               //Key of the hook of the response in context
+	streamSinkKey                                 //Key of the rpcStreamSink in context
+	streamHandlerKey                              //Key of the handler of the streamed elements in context
+	inFlightKey                                   //Key of the rpcInFlight which tracks the request in context
+	eventsPeerKey                                 //Key of the RpcEventsPeer which sent the request in context
+)
+
+// RpcTransportInfo The metadata of the transport which received the request.
+type RpcTransportInfo struct {
+	Engine          string            //The name of the engine which received the request
+	RemoteAddr      string            //The address of the caller, empty if the engine does not know it
+	Header          http.Header       //The headers sent by the caller, nil if the transport has no headers
+	Identity        string            //The subject of the verified client certificate, empty without mutual TLS
+	PeerCertificate *x509.Certificate //The verified client certificate, nil without mutual TLS
+}
+
+// RpcRequestInfo The metadata of the request which is being invoked.
+type RpcRequestInfo struct {
+	ServiceName string //The name of the service
+	Method      string //The method name of the request
+	Id          any    //The id of the request, nil for notification
+}
+
+// WithTransportInfo Attach the transport metadata to the context, engines call it before dispatching.
+func WithTransportInfo(ctx context.Context, info *RpcTransportInfo) context.Context {
+	return context.WithValue(ctx, transportInfoKey, info)
+}
+
+// TransportInfoFromContext Get the transport metadata from the context, nil if not exists.
+func TransportInfoFromContext(ctx context.Context) *RpcTransportInfo {
+	info, _ := ctx.Value(transportInfoKey).(*RpcTransportInfo)
+	return info
+}
+
+// RemoteAddrFromContext Get the address of the caller from the context.
+func RemoteAddrFromContext(ctx context.Context) string {
+	info := TransportInfoFromContext(ctx)
+	if info == nil {
+		return ""
+	}
+	return info.RemoteAddr
+}
+
+// IdentityFromContext Get the caller identity which is the subject of the verified client certificate, empty if the
+// caller was not verified by mutual TLS.
+func IdentityFromContext(ctx context.Context) string {
+	info := TransportInfoFromContext(ctx)
+	if info == nil {
+		return ""
+	}
+	return info.Identity
+}
+
+// HeaderFromContext Get the headers sent by the caller from the context.
+func HeaderFromContext(ctx context.Context) http.Header {
+	info := TransportInfoFromContext(ctx)
+	if info == nil {
+		return nil
+	}
+	return info.Header
+}
+
+// withRequestInfo Attach the request metadata to the context.
+func withRequestInfo(ctx context.Context, info *RpcRequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey, info)
+}
+
+// RequestInfoFromContext Get the request metadata from the context, nil if not exists.
+func RequestInfoFromContext(ctx context.Context) *RpcRequestInfo {
+	info, _ := ctx.Value(requestInfoKey).(*RpcRequestInfo)
+	return info
+}
+
+// RequestIdFromContext Get the id of the request from the context.
+func RequestIdFromContext(ctx context.Context) any {
+	info := RequestInfoFromContext(ctx)
+	if info == nil {
+		return nil
+	}
+	return info.Id
+}
+
+// withPeer Attach the peer which received the request to the context.
+func withPeer(ctx context.Context, peer *RpcPeer) context.Context {
+	return context.WithValue(ctx, peerKey, peer)
+}
+
+// PeerFromContext Get the peer which received the request, nil if the request was not sent over a persistent
+// connection. Service methods can call back the other end through it.
+func PeerFromContext(ctx context.Context) *RpcPeer {
+	peer, _ := ctx.Value(peerKey).(*RpcPeer)
+	return peer
+}
+
+//A context which keeps the values of the parent but is never cancelled.
+type rpcDetachedContext struct {
+	context.Context
+}
+
+func (ctx rpcDetachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (ctx rpcDetachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (ctx rpcDetachedContext) Err() error {
+	return nil
+}
+
+// detachContext Create a context which keeps the values of ctx but is not cancelled with it.
+func detachContext(ctx context.Context) context.Context {
+	return rpcDetachedContext{ctx}
+}
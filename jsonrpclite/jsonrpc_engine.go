@@ -1,11 +1,17 @@
 package jsonrpclite
 
 import (
+	"bufio"
 	"bytes"
-	"encoding/json"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -14,12 +20,19 @@ import (
 type RpcServerEngine interface {
 	// GetName Get the engine name.
 	GetName() string
-	//Start the engine and initialize the router.
-	Start(router *rpcRouter)
+	//Start the engine and initialize the router, the error is returned if it can not listen.
+	Start(router *rpcRouter) error
 	//Stop the engine and free the router.
 	Stop()
 }
 
+// RpcListenerEngine A server engine which listens on a network address.
+type RpcListenerEngine interface {
+	RpcServerEngine
+	// Addr Get the address which the engine listens on, nil if it is not started.
+	Addr() net.Addr
+}
+
 type RpcClientEngine interface {
 	// GetName Get the engine name.
 	GetName() string
@@ -31,46 +44,143 @@ type RpcClientEngine interface {
 	Close()
 }
 
+// RpcContextClientEngine A client engine which can cancel the request by the context and returns the transport
+// failures as error instead of panicking.
+type RpcContextClientEngine interface {
+	RpcClientEngine
+	// ProcessStringContext Send the rpc request string to the server, transport failures are returned as error.
+	ProcessStringContext(ctx context.Context, serviceName string, requestStr string) (string, error)
+}
+
+// RpcPushEngine A server engine over persistent connections which can push notifications to the connected peers.
+type RpcPushEngine interface {
+	RpcServerEngine
+	// NotifyPeer Send the notification to the connected peer with the id.
+	NotifyPeer(peerId string, method string, params []any) error
+	// NotifyGroup Send the notification to the peers in the group, returns the count of peers which it was written to.
+	NotifyGroup(group string, method string, params []any) (int, error)
+	// Broadcast Send the notification to all connected peers, returns the count of peers which it was written to.
+	Broadcast(method string, params []any) (int, error)
+}
+
+// RpcNotificationEngine A client engine over persistent connections which receives the notifications from the server.
+type RpcNotificationEngine interface {
+	RpcClientEngine
+	// OnNotification Register the handler of the notification method.
+	OnNotification(method string, handler any)
+	// Subscribe Call the subscription method and pass its events to the handler, returns the subscription id.
+	Subscribe(ctx context.Context, serviceName string, method string, params []any, handler any) (string, error)
+	// Unsubscribe Cancel the subscription.
+	Unsubscribe(ctx context.Context, serviceName string, subscriptionId string) error
+}
+
+// RpcStreamEngine A client engine which passes the elements of the streamed results to the handler as they arrive.
+type RpcStreamEngine interface {
+	RpcClientEngine
+	// Stream Call the method which returns a channel and pass the elements to the handler in order.
+	Stream(ctx context.Context, serviceName string, method string, params []any, handler any) error
+}
+
 // RpcServerEngineCore The basic server engine for other engines
 type RpcServerEngineCore struct {
-	_router *rpcRouter
+	_router  *rpcRouter
+	inFlight rpcInFlight //The requests in process
 }
 
-// SetRouter Initialize the router for the engine.
+// SetRouter Initialize the router for the engine, the engine takes new requests again if it was drained.
 func (engine *RpcServerEngineCore) SetRouter(router *rpcRouter) {
 	engine._router = router
+	if router != nil {
+		engine.inFlight.resume()
+	}
 }
 
 // ServiceExists check whether the service is available.
 func (engine *RpcServerEngineCore) ServiceExists(serviceName string) bool {
-	return engine._router.getService(serviceName) != nil
+	router := engine._router
+	return router != nil && router.getService(serviceName) != nil
+}
+
+// ResolveServiceName Get the service of the request whose method is qualified as "Service.Method",
+// the defaultService is used if the method is not qualified. The first request decides for a batch, use
+// DispatchQualifiedContext to dispatch the requests of a batch to their own services.
+func (engine *RpcServerEngineCore) ResolveServiceName(requestStr string, defaultService string) string {
+	router := engine._router
+	if router == nil {
+		var err any = errors.New(" The rpc router has not been initialized. ")
+		panic(err)
+	}
+	method, id, err := peekRequest(requestStr)
+	if err != nil {
+		errStr := fmt.Sprintln("Invalid JSON was received by the server. An error occurred on the server while parsing the JSON text.") + err.Error()
+		panic(any(newRpcResponseError(rpcResponse{nil, true, newRpcError(ParseErrorCode, errStr)})))
+	}
+	service := router.findServiceOfMethod(method)
+	if service != nil {
+		return service.name
+	}
+	if defaultService == "" {
+		errStr := "The method does not exist / is not available."
+		panic(any(newRpcResponseError(rpcResponse{id, true, newRpcError(MethodNotFoundCode, errStr)})))
+	}
+	return defaultService
 }
 
 // Dispatch the request string to the services.
 func (engine *RpcServerEngineCore) Dispatch(serviceName string, requestStr string) string {
-	if engine._router == nil {
+	return engine.DispatchContext(context.Background(), serviceName, requestStr)
+}
+
+// DispatchContext Dispatch the request string to the services, the context is passed to the service methods.
+func (engine *RpcServerEngineCore) DispatchContext(ctx context.Context, serviceName string, requestStr string) string {
+	//The router is read once, the engine may free it while the request is in process.
+	router := engine._router
+	if router == nil {
 		var err any = errors.New(" The rpc router has not been initialized. ")
 		panic(err)
 	}
-	service := engine._router.getService(serviceName)
+	service := router.getService(serviceName)
 	if service != nil {
-		requests := decodeRequestString(service, requestStr)
-		responses := engine._router.dispatchRequests(serviceName, requests)
-		if len(responses) > 0 {
-			result := encodeResponses(responses)
-			return string(result)
-		} else {
-			return ""
-		}
+		requests, isBatch := decodeRequestString(service, requestStr)
+		return engine.dispatchDecoded(ctx, router, requests, isBatch)
 	} else {
 		var err any = errors.New("Service " + serviceName + " does not exist.")
 		panic(err)
 	}
 }
 
+// DispatchQualifiedContext Dispatch the request string whose methods are qualified as "Service.Method", the service
+// is resolved for each request of a batch and the defaultService is used if the method is not qualified.
+func (engine *RpcServerEngineCore) DispatchQualifiedContext(ctx context.Context, defaultService string, requestStr string) string {
+	router := engine._router
+	if router == nil {
+		var err any = errors.New(" The rpc router has not been initialized. ")
+		panic(err)
+	}
+	requests, isBatch := decodeResolvedRequestString(func(method string) *rpcService {
+		return router.resolveService(method, defaultService)
+	}, requestStr)
+	return engine.dispatchDecoded(ctx, router, requests, isBatch)
+}
+
+// dispatchDecoded Invoke the decoded requests and encode the responses, empty if all requests are notifications.
+func (engine *RpcServerEngineCore) dispatchDecoded(ctx context.Context, router *rpcRouter, requests []rpcRequest, isBatch bool) string {
+	ctx, finish, ok := engine.inFlight.begin(ctx)
+	if !ok {
+		return rejectShuttingDown(requests, isBatch)
+	}
+	defer finish()
+	responses := router.dispatchRequests(ctx, requests)
+	if len(responses) > 0 {
+		result := encodeResponses(responses, isBatch)
+		return string(result)
+	} else {
+		return ""
+	}
+}
+
 //The engine for in-process communication
 type rpcInProcessEngine struct {
-	requestId int
 	*RpcServerEngineCore
 }
 
@@ -80,8 +190,9 @@ func (engine *rpcInProcessEngine) GetName() string {
 }
 
 //Start the engine and initialize the router.
-func (engine *rpcInProcessEngine) Start(router *rpcRouter) {
+func (engine *rpcInProcessEngine) Start(router *rpcRouter) error {
 	engine.RpcServerEngineCore.SetRouter(router)
+	return nil
 }
 
 //Stop the engine and free the router.
@@ -89,37 +200,44 @@ func (engine *rpcInProcessEngine) Stop() {
 	engine.RpcServerEngineCore.SetRouter(nil)
 }
 
+// Shutdown Wait for the requests in process until the ctx is done, then free the router.
+func (engine *rpcInProcessEngine) Shutdown(ctx context.Context) error {
+	err := engine.RpcServerEngineCore.Drain(ctx)
+	engine.RpcServerEngineCore.SetRouter(nil)
+	return err
+}
+
 // ProcessString Send the rpc request string to the server.
 func (engine *rpcInProcessEngine) ProcessString(serviceName string, requestStr string) string {
-	return engine.RpcServerEngineCore.Dispatch(serviceName, requestStr)
+	ctx := WithTransportInfo(context.Background(), &RpcTransportInfo{Engine: engine.GetName()})
+	return engine.RpcServerEngineCore.DispatchContext(ctx, serviceName, requestStr)
 }
 
-// ProcessData Send the rpc request data to the server.
-func (engine *rpcInProcessEngine) ProcessData(serviceName string, method string, params []any) string {
-	engine.requestId++
-	data := requestData{"2.0", engine.requestId, method, nil}
-	if len(params) > 0 {
-		if len(params) == 1 {
-			paramData, err := json.Marshal(params[0])
-			if err == nil {
-				data.Params = paramData
-			} else {
-				var sendErr any = errors.New("Send request error: " + err.Error())
-				panic(sendErr)
-			}
-		} else {
-			paramsData, err := json.Marshal(params)
-			if err == nil {
-				data.Params = paramsData
+// ProcessStringContext Send the rpc request string to the server, transport failures are returned as error.
+func (engine *rpcInProcessEngine) ProcessStringContext(ctx context.Context, serviceName string, requestStr string) (response string, err error) {
+	defer func() {
+		var p = any(recover())
+		if p != nil {
+			responseErr, ok := p.(*RpcResponseError)
+			if ok {
+				response = responseErr.response
 			} else {
-				var sendErr any = errors.New("Send request error: " + err.Error())
-				panic(sendErr)
+				err = errors.New("Send request error: " + fmt.Sprintf("%v", p))
 			}
 		}
+	}()
+	if engine._router == nil {
+		return "", errors.New("Send request error: the engine has not been started or has been closed.")
 	}
-	requestData, err := json.Marshal(data)
+	ctx = WithTransportInfo(ctx, &RpcTransportInfo{Engine: engine.GetName()})
+	return engine.RpcServerEngineCore.DispatchContext(ctx, serviceName, requestStr), nil
+}
+
+// ProcessData Send the rpc request data to the server.
+func (engine *rpcInProcessEngine) ProcessData(serviceName string, method string, params []any) string {
+	requestData, err := encodeRequestData(nextRequestId(), method, params)
 	if err == nil {
-		return engine.RpcServerEngineCore.Dispatch(serviceName, string(requestData))
+		return engine.ProcessString(serviceName, string(requestData))
 	} else {
 		var sendErr any = errors.New("Send request error: " + err.Error())
 		panic(sendErr)
@@ -140,10 +258,19 @@ func NewInProcessEngine() (RpcServerEngine, RpcClientEngine) {
 
 type rpcHttpServerHandler struct {
 	engine *rpcHttpServerEngine
+	prefix string //The path prefix which is stripped before the service is resolved, empty if not mounted
 }
 
 func (handler *rpcHttpServerHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 	engine := handler.engine
+	if handler.prefix != "" {
+		stripped := stripPathPrefix(request, handler.prefix)
+		if stripped == nil {
+			http.NotFound(writer, request)
+			return
+		}
+		request = stripped
+	}
 	defer func() {
 		var p = any(recover())
 		if p != nil {
@@ -157,7 +284,28 @@ func (handler *rpcHttpServerHandler) ServeHTTP(writer http.ResponseWriter, reque
 			}
 		}
 	}()
+	if request.Method == "GET" {
+		eventsService := eventsServiceName(request.URL.Path)
+		if eventsService != "" {
+			if engine.ServiceExists(eventsService) {
+				//Server-sent events of the notifications pushed by the server.
+				engine.events.serveEvents(writer, request, eventsService)
+			} else {
+				errStr := "Service " + eventsService + " does not exist."
+				engine.WriteResponseData(writer, http.StatusServiceUnavailable, "text/html", errStr)
+			}
+			return
+		}
+	}
+	if sessionService, sessionId, ok := longPollPath(request.URL.Path); ok {
+		engine.serveLongPoll(writer, request, sessionService, sessionId)
+		return
+	}
 	serviceName := strings.Replace(request.URL.Path, "/", "", -1)
+	if request.Method == "GET" && request.URL.Query().Get("method") != "" {
+		engine.serveGet(writer, request, serviceName)
+		return
+	}
 	if request.Method == "POST" {
 		contentLength := request.ContentLength
 		buffer := new(bytes.Buffer)
@@ -170,7 +318,21 @@ func (handler *rpcHttpServerHandler) ServeHTTP(writer http.ResponseWriter, reque
 			}
 		}
 		if engine.ServiceExists(serviceName) {
-			response := engine.RpcServerEngineCore.Dispatch(serviceName, string(buffer.Bytes()))
+			ctx := WithTransportInfo(request.Context(), httpTransportInfo(engine.GetName(), request))
+			ctx = engine.events.withEventsPeer(ctx, serviceName, request)
+			if acceptsStream(request) {
+				//The elements of the streamed results are written as lines before the final response.
+				ndjson := newRpcNdjsonWriter(writer)
+				response := engine.RpcServerEngineCore.DispatchContext(withStreamSink(ctx, ndjson), serviceName, string(buffer.Bytes()))
+				if response != "" || !ndjson.started {
+					err := ndjson.writeLine([]byte(response))
+					if err != nil {
+						logger.Warning("Write data to client error: " + err.Error())
+					}
+				}
+				return
+			}
+			response := engine.RpcServerEngineCore.DispatchContext(ctx, serviceName, string(buffer.Bytes()))
 			if response != "" {
 				engine.WriteResponseData(writer, http.StatusOK, "application/json", response)
 			} else {
@@ -188,8 +350,13 @@ func (handler *rpcHttpServerHandler) ServeHTTP(writer http.ResponseWriter, reque
 
 //A basic http server engine which uses the build-in http lib.
 type rpcHttpServerEngine struct {
-	server *http.Server
-	port   int
+	server   *http.Server
+	port     int
+	options  *rpcServerOptions    //How the engine listens
+	listener net.Listener         //The listener which the server serves on
+	sessions *rpcLongPollSessions //The long polling sessions
+	tls      *rpcTlsReloader      //The TLS files, nil serves plaintext
+	events   *rpcSseHubs          //The server-sent events clients by service
 	*RpcServerEngineCore
 }
 
@@ -219,20 +386,86 @@ func (engine *rpcHttpServerEngine) WriteResponseData(writer http.ResponseWriter,
 }
 
 //Start the engine and initialize the router.
-func (engine *rpcHttpServerEngine) Start(router *rpcRouter) {
+func (engine *rpcHttpServerEngine) Start(router *rpcRouter) error {
 	if engine.server != nil {
 		logger.Warning("The server of engine already started, will be closed.")
 		engine.Stop()
 	}
+	if engine.tls != nil {
+		_, _, err := engine.tls.current()
+		if err != nil {
+			return err
+		}
+	}
+	listener, err := engine.options.listen("tcp", ":"+strconv.Itoa(engine.port))
+	if err != nil {
+		return errors.New("Listen for engine " + engine.GetName() + " error: " + err.Error())
+	}
 	server := new(http.Server)
 	engine.RpcServerEngineCore.SetRouter(router)
-	handler := new(rpcHttpServerHandler)
-	handler.engine = engine
-	server.Handler = handler
-	server.Addr = ":" + strconv.Itoa(engine.port)
+	server.Handler = newRpcHttpServerHandler(engine, "")
+	server.Addr = listener.Addr().String()
+	engine.server = server
+	engine.listener = listener
+	logger.Info("The engine " + engine.GetName() + " listens on " + listener.Addr().String())
+	if engine.tls != nil {
+		server.TLSConfig = &tls.Config{
+			GetCertificate:     engine.tls.getCertificate,
+			GetConfigForClient: engine.tls.serverConfig,
+		}
+		go func() {
+			logger.Info(server.ServeTLS(listener, "", "").Error())
+		}()
+		return nil
+	}
 	go func() {
-		logger.Info(server.ListenAndServe().Error())
+		logger.Info(server.Serve(listener).Error())
 	}()
+	return nil
+}
+
+// Addr Get the address which the engine listens on, nil if it is not started.
+func (engine *rpcHttpServerEngine) Addr() net.Addr {
+	return listenerAddr(engine.listener)
+}
+
+// ListenerFile Get the listener which can be handed to a new process, see RestartServers.
+func (engine *rpcHttpServerEngine) ListenerFile() (string, *os.File, error) {
+	file, err := listenerFile(engine.listener)
+	return engine.options.key, file, err
+}
+
+// handOff Keep the socket file of the listener when it is closed, the new process serves on it.
+func (engine *rpcHttpServerEngine) handOff() {
+	keepSocketFile(engine.listener)
+}
+
+// Shutdown Stop listening, wait for the requests in process until the ctx is done and then close the events streams,
+// the long polling sessions and the connections.
+func (engine *rpcHttpServerEngine) Shutdown(ctx context.Context) error {
+	server := engine.server
+	engine.server = nil
+	engine.listener = nil
+	shutdown := make(chan error, 1)
+	if server != nil {
+		go func() {
+			shutdown <- server.Shutdown(ctx)
+		}()
+	} else {
+		shutdown <- nil
+	}
+	err := engine.RpcServerEngineCore.Drain(ctx)
+	engine.events.closeAll()
+	engine.sessions.closeAll()
+	serverErr := <-shutdown
+	if serverErr != nil {
+		_ = server.Close()
+		if err == nil {
+			err = serverErr
+		}
+	}
+	engine.RpcServerEngineCore.SetRouter(nil)
+	return err
 }
 
 //Stop the engine and free the router.
@@ -243,22 +476,118 @@ func (engine *rpcHttpServerEngine) Stop() {
 			logger.Warning("Close the server of engine error: " + err.Error())
 		}
 		engine.server = nil
+		engine.listener = nil
 	}
+	engine.events.closeAll()
+	engine.sessions.closeAll()
 	engine.RpcServerEngineCore.SetRouter(nil)
 }
 
-// NewRpcHttpServerEngine Create a new RpcServerHttpEngine which based on the build-in http lib
-func NewRpcHttpServerEngine(port int) RpcServerEngine {
+// NotifyPeer Push the notification to the long polling session or the events client with the peer id.
+func (engine *rpcHttpServerEngine) NotifyPeer(peerId string, method string, params []any) error {
+	err := engine.sessions.NotifyPeer(peerId, method, params)
+	if err == nil {
+		return nil
+	}
+	return engine.events.NotifyPeer(peerId, method, params)
+}
+
+// NotifyGroup Push the notification to the long polling sessions and the events clients in the group.
+func (engine *rpcHttpServerEngine) NotifyGroup(group string, method string, params []any) (int, error) {
+	count, err := engine.sessions.NotifyGroup(group, method, params)
+	if err != nil {
+		return count, err
+	}
+	eventsCount, err := engine.events.NotifyGroup(group, method, params)
+	return count + eventsCount, err
+}
+
+// Broadcast Push the notification to all long polling sessions and events clients.
+func (engine *rpcHttpServerEngine) Broadcast(method string, params []any) (int, error) {
+	count, err := engine.sessions.Broadcast(method, params)
+	if err != nil {
+		return count, err
+	}
+	eventsCount, err := engine.events.Broadcast(method, params)
+	return count + eventsCount, err
+}
+
+// NewRpcHttpServerEngine Create a new RpcServerHttpEngine which based on the build-in http lib, it listens on ":port"
+// unless the options give another address or listener.
+func NewRpcHttpServerEngine(port int, options ...RpcServerOption) RpcServerEngine {
 	engine := new(rpcHttpServerEngine)
 	engine.port = port
+	engine.options = newRpcServerOptions(options)
+	engine.sessions = newRpcLongPollSessions()
+	engine.events = newRpcSseHubs()
 	engine.RpcServerEngineCore = new(RpcServerEngineCore)
 	return engine
 }
 
+// newRpcHttpServerHandler Create the handler which serves the requests by the engine.
+func newRpcHttpServerHandler(engine *rpcHttpServerEngine, prefix string) *rpcHttpServerHandler {
+	handler := new(rpcHttpServerHandler)
+	handler.engine = engine
+	handler.prefix = strings.TrimSuffix(prefix, "/")
+	if handler.prefix != "" && !strings.HasPrefix(handler.prefix, "/") {
+		handler.prefix = "/" + handler.prefix
+	}
+	return handler
+}
+
+// stripPathPrefix Get a shallow copy of the request whose path is relative to the prefix,
+// nil if the path is not under the prefix.
+func stripPathPrefix(request *http.Request, prefix string) *http.Request {
+	path := strings.TrimPrefix(request.URL.Path, prefix)
+	if len(path) == len(request.URL.Path) || (path != "" && path[0] != '/') {
+		return nil
+	}
+	stripped := new(http.Request)
+	*stripped = *request
+	stripped.URL = new(url.URL)
+	*stripped.URL = *request.URL
+	stripped.URL.Path = path
+	stripped.URL.RawPath = ""
+	return stripped
+}
+
+// NotifyPeer Push the notification to the long polling session or the events client with the peer id.
+func (handler *rpcHttpServerHandler) NotifyPeer(peerId string, method string, params []any) error {
+	return handler.engine.NotifyPeer(peerId, method, params)
+}
+
+// NotifyGroup Push the notification to the long polling sessions and the events clients in the group.
+func (handler *rpcHttpServerHandler) NotifyGroup(group string, method string, params []any) (int, error) {
+	return handler.engine.NotifyGroup(group, method, params)
+}
+
+// Broadcast Push the notification to all long polling sessions and events clients.
+func (handler *rpcHttpServerHandler) Broadcast(method string, params []any) (int, error) {
+	return handler.engine.Broadcast(method, params)
+}
+
+// Close End the events streams and the long polling sessions, it should be called before the server which mounts
+// the handler is shut down because the events streams never become idle.
+func (handler *rpcHttpServerHandler) Close() {
+	handler.engine.events.closeAll()
+	handler.engine.sessions.closeAll()
+}
+
+// NewRpcHttpHandler Create a handler which serves the services of the router over http in the mux of the caller.
+// The prefix is the path which the handler is mounted under, like "/rpc" for mux.Handle("/rpc/", handler),
+// so the requests to "/rpc/Service" resolve the "Service", and the paths out of the prefix get 404.
+// The events streams and long polling sessions are served under the prefix as well, and the notifications are
+// pushed to them by NotifyPeer, NotifyGroup and Broadcast of the handler.
+func NewRpcHttpHandler(router *rpcRouter, prefix string) *rpcHttpServerHandler {
+	engine := NewRpcHttpServerEngine(0).(*rpcHttpServerEngine)
+	engine.RpcServerEngineCore.SetRouter(router)
+	return newRpcHttpServerHandler(engine, prefix)
+}
+
 //A basic http client engine which uses the build-in http lib.
 type rpcHttpClientEngine struct {
-	requestId  int
 	serverHost string
+	client     *http.Client
 }
 
 // GetName Get the engine name.
@@ -268,28 +597,7 @@ func (engine *rpcHttpClientEngine) GetName() string {
 
 // ProcessData Send the rpc request data to the server.
 func (engine *rpcHttpClientEngine) ProcessData(serviceName string, method string, params []any) string {
-	engine.requestId++
-	data := requestData{"2.0", engine.requestId, method, nil}
-	if len(params) > 0 {
-		if len(params) == 1 {
-			paramData, err := json.Marshal(params[0])
-			if err == nil {
-				data.Params = paramData
-			} else {
-				var sendErr any = errors.New("Send request error: " + err.Error())
-				panic(sendErr)
-			}
-		} else {
-			paramsData, err := json.Marshal(params)
-			if err == nil {
-				data.Params = paramsData
-			} else {
-				var sendErr any = errors.New("Send request error: " + err.Error())
-				panic(sendErr)
-			}
-		}
-	}
-	requestData, err := json.Marshal(data)
+	requestData, err := encodeRequestData(nextRequestId(), method, params)
 	if err == nil {
 		return engine.ProcessString(serviceName, string(requestData))
 	} else {
@@ -300,27 +608,70 @@ func (engine *rpcHttpClientEngine) ProcessData(serviceName string, method string
 
 // ProcessString Process Send the rpc request to the server.
 func (engine *rpcHttpClientEngine) ProcessString(serviceName string, requestStr string) string {
-	buffer := bytes.NewBufferString(requestStr)
-	http.DefaultClient.Timeout = 5 * time.Second
-	response, err := http.Post(engine.serverHost+"/"+serviceName, "application/json; charset=utf-8", buffer)
+	response, err := engine.ProcessStringContext(context.Background(), serviceName, requestStr)
 	if err == nil {
-		contentLength := response.ContentLength
-		buffer = new(bytes.Buffer)
-		for contentLength > 0 {
-			size, err := buffer.ReadFrom(response.Body)
-			if err == nil {
-				contentLength -= size
-			} else {
-				break
-			}
-		}
-		return string(buffer.Bytes())
+		return response
 	} else {
-		var sendErr any = errors.New("Send request error: " + err.Error())
+		var sendErr any = err
 		panic(sendErr)
 	}
 }
 
+// ProcessStringContext Send the rpc request string to the server, transport failures are returned as error.
+func (engine *rpcHttpClientEngine) ProcessStringContext(ctx context.Context, serviceName string, requestStr string) (string, error) {
+	buffer := bytes.NewBufferString(requestStr)
+	request, err := http.NewRequestWithContext(ctx, "POST", engine.serverHost+"/"+serviceName, buffer)
+	if err != nil {
+		return "", errors.New("Send request error: " + err.Error())
+	}
+	request.Header.Set("Content-Type", "application/json; charset=utf-8")
+	response, err := engine.client.Do(request)
+	if err != nil {
+		return "", errors.New("Send request error: " + err.Error())
+	}
+	defer response.Body.Close()
+	content, err := io.ReadAll(response.Body)
+	if err != nil {
+		return "", errors.New("Read response error: " + err.Error())
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", errors.New("Send request error: " + response.Status + " " + string(content))
+	}
+	return string(content), nil
+}
+
+// Stream Call the method which returns a channel, the elements are read from the chunked response line by line and
+// passed to the handler. The call is not limited by the timeout of the client since the stream can be long.
+func (engine *rpcHttpClientEngine) Stream(ctx context.Context, serviceName string, method string, params []any, handler any) error {
+	eventHandler := newEventHandler(handler)
+	id := nextRequestId()
+	requestData, err := encodeRequestData(id, method, params)
+	if err != nil {
+		return errors.New("Encode request error: " + err.Error())
+	}
+	request, err := http.NewRequestWithContext(ctx, "POST", engine.serverHost+"/"+serviceName, bytes.NewReader(requestData))
+	if err != nil {
+		return errors.New("Send request error: " + err.Error())
+	}
+	request.Header.Set("Content-Type", "application/json; charset=utf-8")
+	request.Header.Set("Accept", StreamContentType+", application/json")
+	streamClient := &http.Client{Transport: engine.client.Transport}
+	response, err := streamClient.Do(request)
+	if err != nil {
+		return errors.New("Send request error: " + err.Error())
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		content, _ := io.ReadAll(response.Body)
+		return errors.New("Send request error: " + response.Status + " " + string(content))
+	}
+	responseStr, err := readNdjsonStream(ctx, bufio.NewReader(response.Body), idKey(id), eventHandler)
+	if err != nil {
+		return err
+	}
+	return decodeResponseString(responseStr, nil)
+}
+
 //Close the engine and free the router.
 func (engine *rpcHttpClientEngine) Close() {
 	//DoNothing
@@ -330,5 +681,6 @@ func (engine *rpcHttpClientEngine) Close() {
 func NewRpcHttpClientEngine(serverHost string) RpcClientEngine {
 	engine := new(rpcHttpClientEngine)
 	engine.serverHost = serverHost
+	engine.client = &http.Client{Timeout: 5 * time.Second}
 	return engine
 }
@@ -1,26 +1,97 @@
 package jsonrpclite
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
 )
 
-type rpcError struct {
-	Code    int    `json:"Code"`
-	Message string `json:"Message"`
+// The error codes defined by the JSON-RPC 2.0 specification.
+const (
+	ParseErrorCode     = -32700 //Invalid JSON was received by the server
+	InvalidRequestCode = -32600 //The JSON sent is not a valid Request object
+	MethodNotFoundCode = -32601 //The method does not exist / is not available
+	InvalidParamsCode  = -32602 //Invalid method parameter(s)
+	InternalErrorCode  = -32603 //Internal JSON-RPC error
+	ServerErrorCodeMin = -32099 //The lower bound of the codes reserved for implementation-defined server errors
+	ServerErrorCodeMax = -32000 //The upper bound of the codes reserved for implementation-defined server errors
+)
+
+type RpcErrorFormat uint8
+
+const (
+	StandardErrorFormat RpcErrorFormat = iota //The error object with code/message/data members
+	LegacyErrorFormat                         //The error object with Code/Message members for the old clients
+)
+
+var errorFormat = StandardErrorFormat
+
+// SetRpcErrorFormat Choose how the error objects are encoded, LegacyErrorFormat keeps the old shape for existing clients.
+func SetRpcErrorFormat(format RpcErrorFormat) {
+	errorFormat = format
 }
 
-func (err *rpcError) Error() string {
+// RpcError The error object of a JSON-RPC response, service methods can return it to choose the code and attach data.
+type RpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+func (err *RpcError) Error() string {
 	return "rpcError:" + fmt.Sprintf("%v", err.Code) + "; " + err.Message
 }
 
-// newRpcError Create a RpcError
-func newRpcError(code int, msg string) error {
-	err := new(rpcError)
+// MarshalJSON Encode the error object with the format chosen by SetRpcErrorFormat.
+func (err RpcError) MarshalJSON() ([]byte, error) {
+	if errorFormat == LegacyErrorFormat {
+		return json.Marshal(struct {
+			Code    int
+			Message string
+		}{err.Code, err.Message})
+	}
+	type standardRpcError RpcError
+	return json.Marshal(standardRpcError(err))
+}
+
+// NewRpcError Create a RpcError with code, message and optional data.
+func NewRpcError(code int, message string, data any) *RpcError {
+	err := new(RpcError)
 	err.Code = code
-	err.Message = msg
+	err.Message = message
+	err.Data = data
 	return err
 }
 
+// NewRpcServerError Create a RpcError with a code in the reserved server error range -32099..-32000.
+func NewRpcServerError(code int, message string, data any) *RpcError {
+	if !IsServerErrorCode(code) {
+		var err any = errors.New("The server error code " + strconv.Itoa(code) + " is out of range -32099..-32000")
+		panic(err)
+	}
+	return NewRpcError(code, message, data)
+}
+
+// IsServerErrorCode Check whether the code is in the reserved server error range.
+func IsServerErrorCode(code int) bool {
+	return code >= ServerErrorCodeMin && code <= ServerErrorCodeMax
+}
+
+// newRpcError Create a RpcError
+func newRpcError(code int, msg string) error {
+	return NewRpcError(code, msg, nil)
+}
+
+// toRpcError Convert the error returned by a service method to RpcError.
+func toRpcError(err error) *RpcError {
+	var rpcErr *RpcError
+	if errors.As(err, &rpcErr) {
+		return rpcErr
+	}
+	return NewRpcError(ServerErrorCodeMax, err.Error(), nil)
+}
+
 // RpcResponseError An error which contains the response string.
 type RpcResponseError struct {
 	response string
@@ -32,6 +103,6 @@ func (responseError *RpcResponseError) Error() string {
 
 func newRpcResponseError(response rpcResponse) *RpcResponseError {
 	err := new(RpcResponseError)
-	err.response = string(encodeResponses([]rpcResponse{response}))
+	err.response = string(encodeResponses([]rpcResponse{response}, false))
 	return err
 }
@@ -0,0 +1,166 @@
+package jsonrpclite
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// rpcMaxMessageSize The max size of a message read from a stream.
+const rpcMaxMessageSize = 64 << 20
+
+// RpcFraming Split the byte stream of a connection into JSON-RPC messages.
+type RpcFraming interface {
+	// GetName Get the framing name.
+	GetName() string
+	// ReadMessage Read a whole message from the reader.
+	ReadMessage(reader *bufio.Reader) ([]byte, error)
+	// WriteMessage Write a whole message to the writer.
+	WriteMessage(writer io.Writer, message []byte) error
+}
+
+var (
+	// NewlineFraming Each message is a single line of JSON ended with '\n'.
+	NewlineFraming RpcFraming = new(rpcNewlineFraming)
+	// ContentLengthFraming Each message is preceded by a Content-Length header as the Language Server Protocol does.
+	ContentLengthFraming RpcFraming = new(rpcContentLengthFraming)
+)
+
+// The framing of newline-delimited JSON.
+type rpcNewlineFraming struct {
+}
+
+// GetName Get the framing name.
+func (framing *rpcNewlineFraming) GetName() string {
+	return "NewlineFraming"
+}
+
+// ReadMessage Read the next non-empty line.
+func (framing *rpcNewlineFraming) ReadMessage(reader *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		part, isPrefix, err := reader.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, part...)
+		if len(line) > rpcMaxMessageSize {
+			return nil, errors.New("The message is too large.")
+		}
+		if isPrefix {
+			continue
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			return line, nil
+		}
+		line = line[:0]
+	}
+}
+
+// WriteMessage Write the message as a single line, the JSON is compacted to remove the line breaks.
+func (framing *rpcNewlineFraming) WriteMessage(writer io.Writer, message []byte) error {
+	buffer := new(bytes.Buffer)
+	err := json.Compact(buffer, message)
+	if err != nil {
+		return err
+	}
+	buffer.WriteByte('\n')
+	_, err = writer.Write(buffer.Bytes())
+	return err
+}
+
+// The framing of Content-Length headers.
+type rpcContentLengthFraming struct {
+}
+
+// GetName Get the framing name.
+func (framing *rpcContentLengthFraming) GetName() string {
+	return "ContentLengthFraming"
+}
+
+// ReadMessage Read the headers and then the content with the length in Content-Length.
+func (framing *rpcContentLengthFraming) ReadMessage(reader *bufio.Reader) ([]byte, error) {
+	header, err := textproto.NewReader(reader).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	lengthStr := header.Get("Content-Length")
+	if lengthStr == "" {
+		return nil, errors.New("Missing Content-Length header.")
+	}
+	length, err := strconv.Atoi(strings.TrimSpace(lengthStr))
+	if err != nil || length < 0 {
+		return nil, errors.New("Invalid Content-Length header: " + lengthStr)
+	}
+	if length > rpcMaxMessageSize {
+		return nil, errors.New("The message is too large.")
+	}
+	message := make([]byte, length)
+	_, err = io.ReadFull(reader, message)
+	if err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// WriteMessage Write the Content-Length header and the message.
+func (framing *rpcContentLengthFraming) WriteMessage(writer io.Writer, message []byte) error {
+	buffer := new(bytes.Buffer)
+	buffer.WriteString("Content-Length: " + strconv.Itoa(len(message)) + "\r\n\r\n")
+	buffer.Write(message)
+	_, err := writer.Write(buffer.Bytes())
+	return err
+}
+
+// A connection over a byte stream which is split into messages by the framing.
+type rpcStreamConn struct {
+	reader      *bufio.Reader
+	writer      io.Writer
+	closer      io.Closer
+	framing     RpcFraming
+	address     string
+	writeLocker *sync.Mutex
+}
+
+// readMessage Read a whole message from the stream.
+func (stream *rpcStreamConn) readMessage() ([]byte, error) {
+	return stream.framing.ReadMessage(stream.reader)
+}
+
+// writeMessage Write a whole message to the stream.
+func (stream *rpcStreamConn) writeMessage(message []byte) error {
+	stream.writeLocker.Lock()
+	defer stream.writeLocker.Unlock()
+	return stream.framing.WriteMessage(stream.writer, message)
+}
+
+// close Close the stream.
+func (stream *rpcStreamConn) close() error {
+	if stream.closer == nil {
+		return nil
+	}
+	return stream.closer.Close()
+}
+
+// remoteAddr Get the address of the other side.
+func (stream *rpcStreamConn) remoteAddr() string {
+	return stream.address
+}
+
+// newRpcStreamConn Create a connection over the byte stream with the framing.
+func newRpcStreamConn(reader io.Reader, writer io.Writer, closer io.Closer, framing RpcFraming, address string) *rpcStreamConn {
+	stream := new(rpcStreamConn)
+	stream.reader = bufio.NewReader(reader)
+	stream.writer = writer
+	stream.closer = closer
+	stream.framing = framing
+	stream.address = address
+	stream.writeLocker = new(sync.Mutex)
+	return stream
+}
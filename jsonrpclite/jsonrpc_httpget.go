@@ -0,0 +1,103 @@
+package jsonrpclite
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// serveGet Call the safe or idempotent method by GET /Service?method=Method&params=<json>&id=<json>, the params is
+// optional and the id which is not a JSON text is taken as a string. The result carries the ETag and Cache-Control
+// of the method, and 304 is answered if the ETag matches If-None-Match. The error responses are not cached.
+func (engine *rpcHttpServerEngine) serveGet(writer http.ResponseWriter, request *http.Request, serviceName string) {
+	service := engine._router.getService(serviceName)
+	if service == nil {
+		errStr := "Service " + serviceName + " does not exist."
+		engine.WriteResponseData(writer, http.StatusServiceUnavailable, "text/html", errStr)
+		return
+	}
+	query := request.URL.Query()
+	methodName := strings.TrimPrefix(query.Get("method"), serviceName+".")
+	method := service.methods[methodName]
+	if method != nil && !method.getAllowed {
+		writer.Header().Set("Allow", "POST")
+		errStr := "The method " + methodName + " is neither safe nor idempotent and can not be called by http GET."
+		engine.WriteResponseData(writer, http.StatusMethodNotAllowed, "text/html", errStr)
+		return
+	}
+	//The errors are not cached, including the ones of the invalid request which panic from here.
+	writer.Header().Set("Cache-Control", "no-store")
+	ctx := WithTransportInfo(request.Context(), httpTransportInfo(engine.GetName(), request))
+	ctx = engine.events.withEventsPeer(ctx, serviceName, request)
+	response := engine.RpcServerEngineCore.DispatchContext(ctx, serviceName, getRequestString(query))
+	if isErrorResponse(response) {
+		engine.WriteResponseData(writer, http.StatusOK, "application/json", response)
+		return
+	}
+	etag := responseETag(response)
+	writer.Header().Set("ETag", etag)
+	writer.Header().Set("Cache-Control", method.cacheControl)
+	if etagMatches(request.Header.Get("If-None-Match"), etag) {
+		engine.WriteResponseData(writer, http.StatusNotModified, "", "")
+		return
+	}
+	engine.WriteResponseData(writer, http.StatusOK, "application/json", response)
+}
+
+// getRequestString Build the request string from the query of the http GET.
+func getRequestString(query url.Values) string {
+	id := query.Get("id")
+	if id == "" {
+		errStr := fmt.Sprintln("The JSON sent is not a valid Request object.") + "The id is required by http GET."
+		panic(any(newRpcResponseError(rpcResponse{nil, true, newRpcError(InvalidRequestCode, errStr)})))
+	}
+	idData := json.RawMessage(id)
+	if !json.Valid(idData) {
+		idData, _ = json.Marshal(id)
+	}
+	methodData, _ := json.Marshal(query.Get("method"))
+	data := map[string]json.RawMessage{"jsonrpc": json.RawMessage(`"2.0"`), "method": methodData, "id": idData}
+	params := query.Get("params")
+	if params != "" {
+		if !json.Valid([]byte(params)) {
+			errStr := fmt.Sprintln("Invalid JSON was received by the server. An error occurred on the server while parsing the JSON text.") + "The params is not a valid JSON text."
+			panic(any(newRpcResponseError(rpcResponse{idData, true, newRpcError(ParseErrorCode, errStr)})))
+		}
+		data["params"] = json.RawMessage(params)
+	}
+	requestData, _ := json.Marshal(data)
+	return string(requestData)
+}
+
+// isErrorResponse Check whether the response is an error.
+func isErrorResponse(response string) bool {
+	var data struct {
+		Error json.RawMessage `json:"error"`
+	}
+	err := json.Unmarshal([]byte(response), &data)
+	return err != nil || len(data.Error) > 0
+}
+
+// responseETag Get the strong ETag of the response.
+func responseETag(response string) string {
+	sum := sha256.Sum256([]byte(response))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches Check whether the If-None-Match header matches the ETag, the weak ones are compared weakly.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, item := range strings.Split(ifNoneMatch, ",") {
+		item = strings.TrimPrefix(strings.TrimSpace(item), "W/")
+		if item == "*" || item == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+package jsonrpclite
+
+import "context"
+
+// RpcInvocation The invocation of a service method which is passed through the interceptors.
+type RpcInvocation struct {
+	Context     context.Context //The context of the request, interceptors can replace it for the next ones
+	ServiceName string          //The name of the service
+	MethodName  string          //The name of the method
+	RequestId   any             //The id of the request, nil for notification
+	Params      []any           //The decoded params, interceptors can replace the values with ones of the same types
+}
+
+// RpcInvoker Invoke the next interceptor or the service method at the end of the chain.
+type RpcInvoker func(invocation *RpcInvocation) (any, error)
+
+// RpcInterceptor Wrap an invocation, call next to continue or return directly to short-circuit the call.
+// The elements of a streamed result are all sent before next returns, it then returns their count, or the slice of them
+// if the transport can not stream.
+type RpcInterceptor func(invocation *RpcInvocation, next RpcInvoker) (any, error)
+
+// chainInterceptors Build the invoker which runs the interceptors in order around the final invoker.
+func chainInterceptors(interceptors []RpcInterceptor, final RpcInvoker) RpcInvoker {
+	invoker := final
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor := interceptors[i]
+		next := invoker
+		invoker = func(invocation *RpcInvocation) (any, error) {
+			return interceptor(invocation, next)
+		}
+	}
+	return invoker
+}
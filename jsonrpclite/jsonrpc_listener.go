@@ -0,0 +1,152 @@
+package jsonrpclite
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// RpcServerOption Configure how a server engine listens, see WithAddress, WithListener and WithSystemdListener.
+type RpcServerOption func(options *rpcServerOptions)
+
+// The listening options of a server engine.
+type rpcServerOptions struct {
+	address  string       //The address to listen on, empty keeps the one of the engine
+	listener net.Listener //The listener given by the caller
+	used     bool         //The listener given by the caller has been served, it is closed when the engine stops
+	systemd  bool         //Use the listener passed by systemd socket activation
+	fdName   string       //The name of the systemd listener, empty for the first one
+	key      string       //The key of the listener which a restarted process takes it over by
+}
+
+// WithAddress Listen on the address instead of ":port", like "127.0.0.1:8080", "[::1]:8080" or ":0" for an
+// ephemeral port, the chosen one is got by Addr() after Start.
+func WithAddress(address string) RpcServerOption {
+	return func(options *rpcServerOptions) {
+		options.address = address
+	}
+}
+
+// WithListener Serve on the listener given by the caller, the engine closes it when it stops.
+func WithListener(listener net.Listener) RpcServerOption {
+	return func(options *rpcServerOptions) {
+		options.listener = listener
+	}
+}
+
+// WithSystemdListener Serve on the socket passed by systemd socket activation (LISTEN_PID and LISTEN_FDS), the name
+// matches FileDescriptorName= of the socket unit, empty takes the first socket.
+func WithSystemdListener(name string) RpcServerOption {
+	return func(options *rpcServerOptions) {
+		options.systemd = true
+		options.fdName = name
+	}
+}
+
+// newRpcServerOptions Apply the options.
+func newRpcServerOptions(options []RpcServerOption) *rpcServerOptions {
+	serverOptions := new(rpcServerOptions)
+	for _, option := range options {
+		option(serverOptions)
+	}
+	return serverOptions
+}
+
+// listenerKey Get the key of the listener on the network address, it is the same in the restarted process.
+func (options *rpcServerOptions) listenerKey(network string, defaultAddress string) string {
+	address := defaultAddress
+	if options.address != "" {
+		address = options.address
+	}
+	return network + "|" + address
+}
+
+// injected Check whether the listener is given by the caller, systemd or the process which restarted this one
+// rather than created by the engine.
+func (options *rpcServerOptions) injected(network string, defaultAddress string) bool {
+	return options.listener != nil || options.systemd || hasInheritedListener(options.listenerKey(network, defaultAddress))
+}
+
+// listen Get the listener handed over by the process which restarted this one, the listener given by the options,
+// or listen on the address of the options or the defaultAddress.
+// The listener of systemd is created for each call, so the engine can start again after it stops.
+func (options *rpcServerOptions) listen(network string, defaultAddress string) (net.Listener, error) {
+	options.key = options.listenerKey(network, defaultAddress)
+	listener, err := takeInheritedListener(options.key)
+	if listener != nil || err != nil {
+		return listener, err
+	}
+	if options.listener != nil {
+		if options.used {
+			return nil, errors.New("The listener given by WithListener has been closed when the engine stopped.")
+		}
+		options.used = true
+		return options.listener, nil
+	}
+	if options.systemd {
+		return systemdListener(options.fdName)
+	}
+	return net.Listen(network, strings.TrimPrefix(options.key, network+"|"))
+}
+
+// The first file descriptor passed by systemd.
+const systemdFdStart = 3
+
+var systemdFiles struct {
+	once  sync.Once
+	files []*os.File
+	names []string
+	err   error
+}
+
+// loadSystemdFiles Take the file descriptors passed by systemd once, they are kept open so a listener can be created
+// from them again.
+func loadSystemdFiles() ([]*os.File, []string, error) {
+	systemdFiles.once.Do(func() {
+		pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+		if err != nil || pid != os.Getpid() {
+			systemdFiles.err = errors.New("No socket is passed by systemd to this process.")
+			return
+		}
+		count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		if err != nil || count <= 0 {
+			systemdFiles.err = errors.New("No socket is passed by systemd to this process.")
+			return
+		}
+		names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+		for i := 0; i < count; i++ {
+			name := "LISTEN_FD_" + strconv.Itoa(systemdFdStart+i)
+			if i < len(names) && names[i] != "" {
+				name = names[i]
+			}
+			systemdFiles.files = append(systemdFiles.files, os.NewFile(uintptr(systemdFdStart+i), name))
+			systemdFiles.names = append(systemdFiles.names, name)
+		}
+	})
+	return systemdFiles.files, systemdFiles.names, systemdFiles.err
+}
+
+// systemdListener Create a listener from the socket passed by systemd with the name, empty for the first one.
+func systemdListener(name string) (net.Listener, error) {
+	files, names, err := loadSystemdFiles()
+	if err != nil {
+		return nil, err
+	}
+	for i, file := range files {
+		if name == "" || names[i] == name {
+			return net.FileListener(file)
+		}
+	}
+	return nil, errors.New("No socket named " + name + " is passed by systemd.")
+}
+
+// listenerAddr Get the address of the listener, nil if it is not listening.
+func listenerAddr(listener net.Listener) net.Addr {
+	if listener == nil {
+		return nil
+	}
+	return listener.Addr()
+}
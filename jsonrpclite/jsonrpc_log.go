@@ -2,6 +2,8 @@ package jsonrpclite
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"sync"
 	"time"
 )
@@ -44,15 +46,24 @@ func SetRpcLogger(l RpcLogger) {
 //Default logger for print log on console.
 type rpcConsoleLogger struct {
 	locker *sync.Mutex
+	writer io.Writer
 }
 
 //Create the default console logger.
 func newConsoleLogger() RpcLogger {
 	l := new(rpcConsoleLogger)
 	l.locker = new(sync.Mutex)
+	l.writer = os.Stdout
 	return l
 }
 
+//Change where the log is written, the engines which own stdout move the log to stderr.
+func (l *rpcConsoleLogger) setWriter(writer io.Writer) {
+	l.locker.Lock()
+	l.writer = writer
+	l.locker.Unlock()
+}
+
 //Write the log by different level.
 func (l *rpcConsoleLogger) log(level LogLevel, msg string) {
 	defer func() {
@@ -64,7 +75,7 @@ func (l *rpcConsoleLogger) log(level LogLevel, msg string) {
 	now := time.Now()
 	timeStr := now.Format("2006-01-02 15:04:05.000000000")
 	l.locker.Lock()
-	fmt.Println(levels[level] + "- [" + timeStr + "] " + msg)
+	_, _ = fmt.Fprintln(l.writer, levels[level]+"- ["+timeStr+"] "+msg)
 	l.locker.Unlock()
 }
 
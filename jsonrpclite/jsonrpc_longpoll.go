@@ -0,0 +1,512 @@
+package jsonrpclite
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	longPollTimeout    = 25 * time.Second       //The time a poll is parked on the server when there is no message
+	longPollExpiry     = 60 * time.Second       //The session is closed if the client does not poll for this time
+	longPollQueueLimit = 4096                   //The max count of queued messages, the session is closed when it is exceeded
+	longPollRetryDelay = 500 * time.Millisecond //The delay before polling again after a transient error
+	longPollMaxDelay   = 10 * time.Second       //The cap of the retry delay which doubles on each error in a row
+)
+
+// LongPollCursorHeader The header of the poll response which carries the cursor after the returned messages, the
+// client acknowledges them by sending it back as ?ack= in the next poll so the lost responses are polled again.
+const LongPollCursorHeader = "X-Poll-Cursor"
+
+// The server side of a long polling session, the client posts the messages and polls the queued ones.
+type rpcLongPollConn struct {
+	address   string
+	incoming  chan []byte //The messages posted by the client
+	locker    *sync.Mutex
+	queue     [][]byte      //The messages waiting for the poll or the acknowledgement
+	first     uint64        //The sequence of the first queued message
+	signal    chan struct{} //Notified when a message is queued
+	closed    chan struct{}
+	closeOnce *sync.Once
+	expiry    *time.Timer //Close the session when the client stops polling
+}
+
+// readMessage Get the next message posted by the client.
+func (conn *rpcLongPollConn) readMessage() ([]byte, error) {
+	select {
+	case message := <-conn.incoming:
+		return message, nil
+	case <-conn.closed:
+		return nil, io.EOF
+	}
+}
+
+// writeMessage Queue the message for the next poll.
+func (conn *rpcLongPollConn) writeMessage(message []byte) error {
+	conn.locker.Lock()
+	defer conn.locker.Unlock()
+	select {
+	case <-conn.closed:
+		return errors.New("the session is closed.")
+	default:
+	}
+	if len(conn.queue) >= longPollQueueLimit {
+		go conn.close()
+		return errors.New("the client does not poll the messages in time.")
+	}
+	conn.queue = append(conn.queue, message)
+	select {
+	case conn.signal <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// post Pass the message posted by the client to the reading loop.
+func (conn *rpcLongPollConn) post(ctx context.Context, message []byte) error {
+	select {
+	case conn.incoming <- message:
+		return nil
+	case <-conn.closed:
+		return errors.New("the session is closed.")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// acknowledge Remove the queued messages before the cursor, the caller must hold the locker.
+func (conn *rpcLongPollConn) acknowledge(cursor uint64) {
+	if cursor <= conn.first {
+		return
+	}
+	count := cursor - conn.first
+	if count > uint64(len(conn.queue)) {
+		count = uint64(len(conn.queue))
+	}
+	conn.queue = conn.queue[count:]
+	conn.first += count
+}
+
+// poll Wait until there are queued messages or the timeout passes, returns them and the cursor after them. The
+// messages before ack are removed first and the returned ones are kept until the next poll acknowledges them, the
+// client which does not send ack (ack is negative) takes them at once.
+func (conn *rpcLongPollConn) poll(ctx context.Context, timeout time.Duration, ack int64) ([][]byte, uint64, error) {
+	conn.expiry.Reset(longPollExpiry + timeout)
+	defer conn.expiry.Reset(longPollExpiry)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		conn.locker.Lock()
+		if ack >= 0 {
+			conn.acknowledge(uint64(ack))
+		}
+		messages := append([][]byte(nil), conn.queue...)
+		cursor := conn.first + uint64(len(conn.queue))
+		if ack < 0 {
+			conn.acknowledge(cursor)
+		}
+		conn.locker.Unlock()
+		if len(messages) > 0 {
+			return messages, cursor, nil
+		}
+		select {
+		case <-conn.signal:
+		case <-timer.C:
+			return nil, cursor, nil
+		case <-conn.closed:
+			return nil, cursor, errors.New("the session is closed.")
+		case <-ctx.Done():
+			return nil, cursor, ctx.Err()
+		}
+	}
+}
+
+// close Close the session.
+func (conn *rpcLongPollConn) close() error {
+	conn.closeOnce.Do(func() {
+		conn.expiry.Stop()
+		close(conn.closed)
+	})
+	return nil
+}
+
+// remoteAddr Get the address of the client which created the session.
+func (conn *rpcLongPollConn) remoteAddr() string {
+	return conn.address
+}
+
+// newRpcLongPollConn Create the server side of a session.
+func newRpcLongPollConn(address string) *rpcLongPollConn {
+	conn := new(rpcLongPollConn)
+	conn.address = address
+	conn.incoming = make(chan []byte)
+	conn.locker = new(sync.Mutex)
+	conn.signal = make(chan struct{}, 1)
+	conn.closed = make(chan struct{})
+	conn.closeOnce = new(sync.Once)
+	conn.expiry = time.AfterFunc(longPollExpiry, func() {
+		logger.Debug("The long polling session of " + address + " expires.")
+		conn.close()
+	})
+	return conn
+}
+
+// A long polling session of the http server engine.
+type rpcLongPollSession struct {
+	conn *rpcLongPollConn
+	peer *RpcPeer
+}
+
+// The long polling sessions of the http server engine, the session peers can be pushed like websocket ones.
+type rpcLongPollSessions struct {
+	locker   *sync.Mutex
+	sessions map[string]*rpcLongPollSession
+	*rpcPeers
+}
+
+// create Start a session of the service.
+func (sessions *rpcLongPollSessions) create(core *RpcServerEngineCore, serviceName string, transportInfo *RpcTransportInfo) string {
+	conn := newRpcLongPollConn(transportInfo.RemoteAddr)
+	peer := newBoundRpcPeer(conn, core, serviceName, transportInfo)
+	id := newSubscriptionId()
+	sessions.locker.Lock()
+	sessions.sessions[id] = &rpcLongPollSession{conn, peer}
+	sessions.locker.Unlock()
+	sessions.rpcPeers.add(peer)
+	go func() {
+		peer.run()
+		sessions.rpcPeers.remove(peer)
+		sessions.locker.Lock()
+		delete(sessions.sessions, id)
+		sessions.locker.Unlock()
+	}()
+	return id
+}
+
+// get Get the session by id, nil if it does not exist.
+func (sessions *rpcLongPollSessions) get(id string) *rpcLongPollSession {
+	sessions.locker.Lock()
+	defer sessions.locker.Unlock()
+	return sessions.sessions[id]
+}
+
+// newRpcLongPollSessions Create an empty session set.
+func newRpcLongPollSessions() *rpcLongPollSessions {
+	sessions := new(rpcLongPollSessions)
+	sessions.locker = new(sync.Mutex)
+	sessions.sessions = make(map[string]*rpcLongPollSession)
+	sessions.rpcPeers = newRpcPeers()
+	return sessions
+}
+
+// longPollPath Parse the session path like /Service/session or /Service/session/id, ok is false if it is not.
+func longPollPath(path string) (serviceName string, sessionId string, ok bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[1] != "session" {
+		return "", "", false
+	}
+	if len(parts) == 3 {
+		sessionId = parts[2]
+	}
+	return parts[0], sessionId, true
+}
+
+// serveLongPoll Handle the long polling session requests:
+// POST /Service/session creates a session and returns {"session":"id"},
+// POST /Service/session/id sends the messages, GET /Service/session/id?timeout=seconds&ack=cursor polls the queued
+// messages as an array or 204 if the timeout passes, and DELETE /Service/session/id closes the session. The cursor is
+// returned in the LongPollCursorHeader, the messages are kept until a poll acknowledges them.
+func (engine *rpcHttpServerEngine) serveLongPoll(writer http.ResponseWriter, request *http.Request, serviceName string, sessionId string) {
+	if !engine.ServiceExists(serviceName) {
+		errStr := "Service " + serviceName + " does not exist."
+		engine.WriteResponseData(writer, http.StatusServiceUnavailable, "text/html", errStr)
+		return
+	}
+	if sessionId == "" {
+		if request.Method != "POST" {
+			engine.WriteResponseData(writer, http.StatusMethodNotAllowed, "text/html", "Invalid http-method: "+request.Method)
+			return
+		}
+		id := engine.sessions.create(engine.RpcServerEngineCore, serviceName, httpTransportInfo(engine.GetName(), request))
+		content, _ := json.Marshal(map[string]string{"session": id})
+		engine.WriteResponseData(writer, http.StatusOK, "application/json", string(content))
+		return
+	}
+	session := engine.sessions.get(sessionId)
+	if session == nil {
+		engine.WriteResponseData(writer, http.StatusNotFound, "text/html", "Session "+sessionId+" does not exist.")
+		return
+	}
+	switch request.Method {
+	case "POST":
+		content, err := io.ReadAll(io.LimitReader(request.Body, rpcMaxMessageSize))
+		if err == nil {
+			err = session.conn.post(request.Context(), content)
+		}
+		if err != nil {
+			engine.WriteResponseData(writer, http.StatusGone, "text/html", err.Error())
+			return
+		}
+		engine.WriteResponseData(writer, http.StatusAccepted, "", "")
+	case "GET":
+		timeout := longPollTimeout
+		seconds, err := strconv.Atoi(request.URL.Query().Get("timeout"))
+		if err == nil && seconds >= 0 && time.Duration(seconds)*time.Second < timeout {
+			timeout = time.Duration(seconds) * time.Second
+		}
+		ack, err := strconv.ParseInt(request.URL.Query().Get("ack"), 10, 64)
+		if err != nil {
+			ack = -1
+		}
+		messages, cursor, err := session.conn.poll(request.Context(), timeout, ack)
+		if err != nil {
+			engine.WriteResponseData(writer, http.StatusGone, "text/html", err.Error())
+			return
+		}
+		writer.Header().Set(LongPollCursorHeader, strconv.FormatUint(cursor, 10))
+		if len(messages) == 0 {
+			engine.WriteResponseData(writer, http.StatusNoContent, "", "")
+			return
+		}
+		buffer := new(bytes.Buffer)
+		buffer.WriteByte('[')
+		for i, message := range messages {
+			if i > 0 {
+				buffer.WriteByte(',')
+			}
+			buffer.Write(message)
+		}
+		buffer.WriteByte(']')
+		engine.WriteResponseData(writer, http.StatusOK, "application/json", buffer.String())
+	case "DELETE":
+		session.peer.Close()
+		engine.WriteResponseData(writer, http.StatusOK, "", "")
+	default:
+		engine.WriteResponseData(writer, http.StatusMethodNotAllowed, "text/html", "Invalid http-method: "+request.Method)
+	}
+}
+
+// The client side of a long polling session, the messages are posted and the queued ones are polled in background.
+type rpcLongPollClientConn struct {
+	url       string //The url of the session
+	client    *http.Client
+	messages  chan []byte
+	ctx       context.Context //Cancel the parked poll when the connection is closed
+	cancel    context.CancelFunc
+	locker    *sync.Mutex
+	err       error
+	closeOnce *sync.Once
+}
+
+// pollLoop Poll the queued messages until the session is closed, the transient errors are retried with backoff and
+// the messages of the failed polls are polled again because they were not acknowledged.
+func (conn *rpcLongPollClientConn) pollLoop() {
+	timeout := strconv.Itoa(int(longPollTimeout / time.Second))
+	cursor := "0"
+	delay := longPollRetryDelay
+	for {
+		messages, next, err := conn.pollOnce(timeout, cursor)
+		if err != nil {
+			if conn.ctx.Err() != nil {
+				return
+			}
+			var statusErr *rpcLongPollStatusError
+			if errors.As(err, &statusErr) && statusErr.status < 500 && statusErr.status != http.StatusTooManyRequests {
+				//The session is gone or the request is refused, polling again does not help.
+				conn.fail(err)
+				return
+			}
+			logger.Warning("Poll the session " + conn.url + " error: " + err.Error() + ", it will be retried in " +
+				delay.String() + ".")
+			select {
+			case <-time.After(delay):
+			case <-conn.ctx.Done():
+				return
+			}
+			delay *= 2
+			if delay > longPollMaxDelay {
+				delay = longPollMaxDelay
+			}
+			continue
+		}
+		delay = longPollRetryDelay
+		if next != "" {
+			cursor = next
+		}
+		for _, message := range messages {
+			select {
+			case conn.messages <- message:
+			case <-conn.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// The error status of the poll response.
+type rpcLongPollStatusError struct {
+	status int
+	text   string
+}
+
+// Error Get the status and the content of the response.
+func (err *rpcLongPollStatusError) Error() string {
+	return err.text
+}
+
+// pollOnce Poll the queued messages after acknowledging the ones before cursor, returns the cursor after the messages
+// which is empty if the server does not send it.
+func (conn *rpcLongPollClientConn) pollOnce(timeout string, cursor string) ([]json.RawMessage, string, error) {
+	request, err := http.NewRequestWithContext(conn.ctx, "GET", conn.url+"?timeout="+timeout+"&ack="+cursor, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	response, err := conn.client.Do(request)
+	if err != nil {
+		return nil, "", err
+	}
+	content, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, "", err
+	}
+	next := response.Header.Get(LongPollCursorHeader)
+	if response.StatusCode == http.StatusNoContent {
+		return nil, next, nil
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, "", &rpcLongPollStatusError{response.StatusCode, response.Status + " " + strings.TrimSpace(string(content))}
+	}
+	var messages []json.RawMessage
+	err = json.Unmarshal(content, &messages)
+	if err != nil {
+		return nil, "", err
+	}
+	return messages, next, nil
+}
+
+// fail Record the error which stops the connection.
+func (conn *rpcLongPollClientConn) fail(err error) {
+	conn.locker.Lock()
+	if conn.err == nil {
+		conn.err = err
+	}
+	conn.locker.Unlock()
+	conn.cancel()
+}
+
+// readMessage Get the next polled message.
+func (conn *rpcLongPollClientConn) readMessage() ([]byte, error) {
+	select {
+	case message := <-conn.messages:
+		return message, nil
+	case <-conn.ctx.Done():
+		conn.locker.Lock()
+		defer conn.locker.Unlock()
+		if conn.err != nil {
+			return nil, conn.err
+		}
+		return nil, io.EOF
+	}
+}
+
+// writeMessage Post the message to the session.
+func (conn *rpcLongPollClientConn) writeMessage(message []byte) error {
+	request, err := http.NewRequestWithContext(conn.ctx, "POST", conn.url, bytes.NewReader(message))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json; charset=utf-8")
+	response, err := conn.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusAccepted {
+		content, _ := io.ReadAll(response.Body)
+		err = errors.New(response.Status + " " + string(content))
+		conn.fail(err)
+		return err
+	}
+	return nil
+}
+
+// close Close the session on the server and stop polling.
+func (conn *rpcLongPollClientConn) close() error {
+	var err error
+	conn.closeOnce.Do(func() {
+		conn.cancel()
+		request, requestErr := http.NewRequest("DELETE", conn.url, nil)
+		if requestErr != nil {
+			err = requestErr
+			return
+		}
+		client := &http.Client{Transport: conn.client.Transport, Timeout: 5 * time.Second}
+		response, requestErr := client.Do(request)
+		if requestErr != nil {
+			err = requestErr
+			return
+		}
+		response.Body.Close()
+	})
+	return err
+}
+
+// remoteAddr Get the url of the session.
+func (conn *rpcLongPollClientConn) remoteAddr() string {
+	return conn.url
+}
+
+// dialLongPoll Create a session of the service with the url like http://localhost:8080/ServiceName.
+func dialLongPoll(ctx context.Context, serviceUrl string) (rpcMessageConn, error) {
+	client := new(http.Client)
+	request, err := http.NewRequestWithContext(ctx, "POST", serviceUrl+"/session", nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	content, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.New("Create session error: " + response.Status + " " + string(content))
+	}
+	var data struct {
+		Session string `json:"session"`
+	}
+	err = json.Unmarshal(content, &data)
+	if err != nil || data.Session == "" {
+		return nil, errors.New("Create session error: invalid response " + string(content))
+	}
+	conn := new(rpcLongPollClientConn)
+	conn.url = serviceUrl + "/session/" + data.Session
+	conn.client = client
+	conn.messages = make(chan []byte)
+	conn.ctx, conn.cancel = context.WithCancel(context.Background())
+	conn.locker = new(sync.Mutex)
+	conn.closeOnce = new(sync.Once)
+	go conn.pollLoop()
+	return conn, nil
+}
+
+// NewRpcHttpLongPollClientEngine Create a client engine which talks to the http server engine by long polling, the
+// serverHost looks like http://localhost:8080. It keeps a session for each service, so the server can call back and
+// push notifications like over websocket when neither websocket nor server-sent events pass the proxy.
+func NewRpcHttpLongPollClientEngine(serverHost string) RpcClientEngine {
+	engine := newRpcWebSocketClientEngine(serverHost)
+	engine.name = "RpcHttpLongPollClientEngine"
+	engine.dial = dialLongPoll
+	return engine
+}
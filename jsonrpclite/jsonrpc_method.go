@@ -1,38 +1,107 @@
 package jsonrpclite
 
 import (
+	"context"
 	"errors"
 	"reflect"
+	"strconv"
 )
 
 type rpcMethodType uint32
 
 const (
-	returnMethod rpcMethodType = iota //Handler with return value
-	voidMethod                        //Handler without return value
+	returnMethod      rpcMethodType = iota //Handler with return value
+	voidMethod                             //Handler without return value
+	errorMethod                            //Handler with an error as the only return value
+	returnErrorMethod                      //Handler with return value and error
 )
 
-type rpcMethodHandler func(params []any) any
+var (
+	errorType        = reflect.TypeOf((*error)(nil)).Elem()
+	contextType      = reflect.TypeOf((*context.Context)(nil)).Elem()
+	subscriptionType = reflect.TypeOf((*RpcSubscription)(nil))
+)
+
+type rpcMethodHandler func(params []any) (any, error)
 
 type rpcMethod struct {
-	handler    rpcMethodHandler //The method reflect value
-	name       string           //The name of the method
-	methodType rpcMethodType    //The type of the handler
-	paramTypes []reflect.Type   //The types of the handler params
-	returnType reflect.Type     //The type of return value
+	handler      rpcMethodHandler //The method reflect value
+	name         string           //The name of the method
+	methodType   rpcMethodType    //The type of the handler
+	paramTypes   []reflect.Type   //The types of the handler params
+	returnType   reflect.Type     //The type of return value
+	hasContext   bool             //True when the first param after receiver is context.Context
+	subscription bool             //True when the method takes a *RpcSubscription after the receiver and context
+	stream       bool             //True when the method returns a channel whose elements are streamed
+	paramNames   []string         //The names of the params for by-name binding, empty if not declared
+	interceptors []RpcInterceptor //Interceptors for this method only
+	getAllowed   bool             //True when the method is safe or idempotent, so it can be called by http GET
+	cacheControl string           //The Cache-Control header of the responses to http GET
 }
 
 //call the method of the rpcMethod
-func (method *rpcMethod) call(params []any) any {
+func (method *rpcMethod) call(params []any) (any, error) {
 	return method.handler(params)
 }
 
+// allowGet Let the method be called by http GET, the responses carry the cacheControl.
+func (method *rpcMethod) allowGet(cacheControl string) {
+	if method.stream || method.subscription {
+		var err any = errors.New("The method " + method.name + " streams its result and can not be called by http GET.")
+		panic(err)
+	}
+	method.getAllowed = true
+	method.cacheControl = cacheControl
+}
+
+// argTypes Get the types of the params which should be decoded from the request.
+func (method *rpcMethod) argTypes() []reflect.Type {
+	offset := 1
+	if method.hasContext {
+		offset++
+	}
+	if method.subscription {
+		offset++
+	}
+	return method.paramTypes[offset:]
+}
+
+// setParamNames Declare the names of the params, so the by-name params can be bound.
+func (method *rpcMethod) setParamNames(names []string) {
+	argCount := len(method.argTypes())
+	if len(names) != argCount {
+		var err any = errors.New("The param name count of method " + method.name + " should be " + strconv.Itoa(argCount))
+		panic(err)
+	}
+	exists := make(map[string]bool, len(names))
+	for _, name := range names {
+		if name == "" || exists[name] {
+			var err any = errors.New("The param name \"" + name + "\" of method " + method.name + " is empty or duplicated")
+			panic(err)
+		}
+		exists[name] = true
+	}
+	method.paramNames = names
+}
+
+// toError Convert the reflect value of an error return value to error.
+func toError(value reflect.Value) error {
+	if value.IsNil() {
+		return nil
+	}
+	return value.Interface().(error)
+}
+
 // newRpcMethod Create a new rpcMethod instance
 func newRpcMethod(serviceMethod reflect.Method) *rpcMethod {
 	//Parse out
 	outNum := serviceMethod.Type.NumOut()
-	if outNum > 1 {
-		var err any = errors.New("The return value count of method " + serviceMethod.Name + " should be 0 or 1")
+	if outNum > 2 {
+		var err any = errors.New("The return value count of method " + serviceMethod.Name + " should be 0, 1 or 2")
+		panic(err)
+	}
+	if outNum == 2 && serviceMethod.Type.Out(1) != errorType {
+		var err any = errors.New("The second return value of method " + serviceMethod.Name + " should be error")
 		panic(err)
 	}
 	//Parse in
@@ -44,30 +113,89 @@ func newRpcMethod(serviceMethod reflect.Method) *rpcMethod {
 	method := new(rpcMethod)
 	method.name = serviceMethod.Name
 	method.paramTypes = paramTypes
-	if outNum == 0 {
+	method.hasContext = inNum > 1 && paramTypes[1] == contextType
+	returnKind := reflect.Invalid
+	if outNum > 0 {
+		returnKind = serviceMethod.Type.Out(0).Kind()
+	}
+	method.stream = returnKind == reflect.Chan && serviceMethod.Type.Out(0).ChanDir() != reflect.SendDir
+	subscriptionIndex := 1
+	if method.hasContext {
+		subscriptionIndex++
+	}
+	method.subscription = inNum > subscriptionIndex && paramTypes[subscriptionIndex] == subscriptionType
+	if method.subscription && outNum != 0 && (outNum != 1 || serviceMethod.Type.Out(0) != errorType) {
+		var err any = errors.New("The subscription method " + serviceMethod.Name + " should return nothing or an error")
+		panic(err)
+	}
+	callMethod := func(params []any) []reflect.Value {
+		paramCount := len(params)
+		callParams := make([]reflect.Value, paramCount)
+		for i := 0; i < paramCount; i++ {
+			callParams[i] = reflect.ValueOf(params[i])
+		}
+		return serviceMethod.Func.Call(callParams)
+	}
+	switch {
+	case outNum == 0:
 		method.methodType = voidMethod
 		method.returnType = reflect.TypeOf(nil)
-		method.handler = func(params []any) any {
-			paramCount := len(params)
-			callParams := make([]reflect.Value, paramCount)
-			for i := 0; i < paramCount; i++ {
-				callParams[i] = reflect.ValueOf(params[i])
-			}
-			serviceMethod.Func.Call(callParams)
-			return nil
+		method.handler = func(params []any) (any, error) {
+			callMethod(params)
+			return nil, nil
+		}
+	case outNum == 1 && serviceMethod.Type.Out(0) == errorType:
+		method.methodType = errorMethod
+		method.returnType = reflect.TypeOf(nil)
+		method.handler = func(params []any) (any, error) {
+			result := callMethod(params)[0]
+			return nil, toError(result)
 		}
-	} else {
+	case outNum == 1:
 		method.methodType = returnMethod
 		method.returnType = serviceMethod.Type.Out(0)
-		method.handler = func(params []any) any {
-			paramCount := len(params)
-			callParams := make([]reflect.Value, paramCount)
-			for i := 0; i < paramCount; i++ {
-				callParams[i] = reflect.ValueOf(params[i])
+		method.handler = func(params []any) (any, error) {
+			result := callMethod(params)[0]
+			return result.Interface(), nil
+		}
+	default:
+		method.methodType = returnErrorMethod
+		method.returnType = serviceMethod.Type.Out(0)
+		method.handler = func(params []any) (any, error) {
+			results := callMethod(params)
+			err := toError(results[1])
+			if err != nil {
+				return nil, err
 			}
-			result := serviceMethod.Func.Call(callParams)[0]
-			return result.Interface()
+			return results[0].Interface(), nil
 		}
 	}
 	return method
 }
+
+// newFuncMethod Wrap the func as a method of the receiver type, the receiver is ignored when it is called.
+func newFuncMethod(name string, receiverType reflect.Type, handler any) *rpcMethod {
+	handlerValue := reflect.ValueOf(handler)
+	if handlerValue.Kind() != reflect.Func || handlerValue.IsNil() {
+		var err any = errors.New("The handler of " + name + " should be a func")
+		panic(err)
+	}
+	handlerType := handlerValue.Type()
+	inTypes := make([]reflect.Type, 0, handlerType.NumIn()+1)
+	inTypes = append(inTypes, receiverType)
+	for i := 0; i < handlerType.NumIn(); i++ {
+		inTypes = append(inTypes, handlerType.In(i))
+	}
+	outTypes := make([]reflect.Type, 0, handlerType.NumOut())
+	for i := 0; i < handlerType.NumOut(); i++ {
+		outTypes = append(outTypes, handlerType.Out(i))
+	}
+	methodType := reflect.FuncOf(inTypes, outTypes, handlerType.IsVariadic())
+	methodFunc := reflect.MakeFunc(methodType, func(args []reflect.Value) []reflect.Value {
+		if handlerType.IsVariadic() {
+			return handlerValue.CallSlice(args[1:])
+		}
+		return handlerValue.Call(args[1:])
+	})
+	return newRpcMethod(reflect.Method{Name: name, Type: methodType, Func: methodFunc})
+}
@@ -0,0 +1,229 @@
+package jsonrpclite
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// The handlers of the notifications pushed by the other end, they are registered like the methods of a service
+// so the params are decoded in the same way.
+type rpcNotificationHandlers struct {
+	locker        *sync.RWMutex
+	service       *rpcService
+	subscriptions map[string]*rpcMethod //The handlers of the subscription events by subscription id
+	streams       map[string]*rpcMethod //The handlers of the streamed elements by the key of request id
+}
+
+// register Register the handler of the notification method, the handler is a func whose params are decoded from
+// the notification, it can take a context.Context first and return nothing or an error.
+func (handlers *rpcNotificationHandlers) register(method string, handler any) {
+	handlerType := reflect.TypeOf(handler)
+	if handlerType != nil && handlerType.Kind() == reflect.Func &&
+		(handlerType.NumOut() > 1 || (handlerType.NumOut() == 1 && handlerType.Out(0) != errorType)) {
+		var err any = errors.New("The handler of notification " + method + " should return nothing or an error")
+		panic(err)
+	}
+	rpcMethod := newFuncMethod(method, reflect.TypeOf(handlers), handler)
+	handlers.locker.Lock()
+	defer handlers.locker.Unlock()
+	handlers.service.addMethod(rpcMethod)
+}
+
+// handle Call the handler if the message is a notification with a registered handler, returns false otherwise.
+func (handlers *rpcNotificationHandlers) handle(ctx context.Context, message string) bool {
+	methodName, id, err := peekRequest(message)
+	if err != nil || id != nil {
+		return false
+	}
+	if methodName == SubscriptionMethod {
+		return handlers.handleEvent(ctx, message)
+	}
+	handlers.locker.RLock()
+	method := handlers.service.methods[methodName]
+	var requests []rpcRequest
+	isBatch := false
+	if method != nil {
+		requests, isBatch = decodeRequestString(handlers.service, message)
+	}
+	handlers.locker.RUnlock()
+	if method == nil || isBatch || len(requests) != 1 {
+		return false
+	}
+	request := requests[0]
+	if request.err != nil {
+		logger.Warning("Receive invalid notification " + methodName + ": " + request.err.Message)
+		return true
+	}
+	defer func() {
+		var p = any(recover())
+		if p != nil {
+			logger.Warning("Handle notification " + methodName + " error: " + fmt.Sprintf("%v", p))
+		}
+	}()
+	callParams := make([]any, 0, len(request.params)+2)
+	callParams = append(callParams, handlers)
+	if method.hasContext {
+		callParams = append(callParams, ctx)
+	}
+	for i := 0; i < len(request.params); i++ {
+		callParams = append(callParams, request.params[i].value)
+	}
+	_, err = method.call(callParams)
+	if err != nil {
+		logger.Warning("Handle notification " + methodName + " error: " + err.Error())
+	}
+	return true
+}
+
+// newEventHandler Wrap the handler of the subscription events or streamed elements, it takes an optional
+// context.Context and the event, and returns nothing or an error.
+func newEventHandler(handler any) *rpcMethod {
+	method := newFuncMethod("event", reflect.TypeOf((*rpcNotificationHandlers)(nil)), handler)
+	if len(method.argTypes()) != 1 || method.methodType == returnMethod || method.methodType == returnErrorMethod {
+		var err any = errors.New("The handler of events should take the event and return nothing or an error")
+		panic(err)
+	}
+	return method
+}
+
+// callEventHandler Decode the event and pass it to the handler.
+func callEventHandler(ctx context.Context, method *rpcMethod, result json.RawMessage) (err error) {
+	defer func() {
+		var p = any(recover())
+		if p != nil {
+			err = errors.New("Handle event error: " + fmt.Sprintf("%v", p))
+		}
+	}()
+	event := reflect.New(method.argTypes()[0])
+	if len(result) > 0 {
+		err = json.Unmarshal(result, event.Interface())
+		if err != nil {
+			return errors.New("Decode event error: " + err.Error())
+		}
+	}
+	callParams := make([]any, 0, 3)
+	callParams = append(callParams, (*rpcNotificationHandlers)(nil))
+	if method.hasContext {
+		callParams = append(callParams, ctx)
+	}
+	callParams = append(callParams, event.Elem().Interface())
+	_, err = method.call(callParams)
+	return err
+}
+
+// addSubscription Register the handler of the subscription events.
+func (handlers *rpcNotificationHandlers) addSubscription(id string, handler *rpcMethod) {
+	handlers.locker.Lock()
+	defer handlers.locker.Unlock()
+	handlers.subscriptions[id] = handler
+}
+
+// removeSubscription Remove the handler of the subscription events.
+func (handlers *rpcNotificationHandlers) removeSubscription(id string) {
+	handlers.locker.Lock()
+	defer handlers.locker.Unlock()
+	delete(handlers.subscriptions, id)
+}
+
+// handleEvent Pass the subscription event to the handler of the subscription.
+func (handlers *rpcNotificationHandlers) handleEvent(ctx context.Context, message string) bool {
+	var data struct {
+		Params struct {
+			Subscription string          `json:"subscription"`
+			Result       json.RawMessage `json:"result"`
+		} `json:"params"`
+	}
+	err := json.Unmarshal([]byte(message), &data)
+	if err != nil {
+		logger.Warning("Receive invalid subscription event: " + err.Error())
+		return true
+	}
+	handlers.locker.RLock()
+	method := handlers.subscriptions[data.Params.Subscription]
+	handlers.locker.RUnlock()
+	if method == nil {
+		logger.Debug("Receive event of unknown subscription " + data.Params.Subscription)
+		return true
+	}
+	err = callEventHandler(ctx, method, data.Params.Result)
+	if err != nil {
+		logger.Warning("Handle event of subscription " + data.Params.Subscription + " error: " + err.Error())
+	}
+	return true
+}
+
+// handleSubscriptionEvent Pass the subscription event to the handler of the subscription, returns false if the message
+// is not a subscription event. It is called on the read loop so the events reach the handler in order.
+func (handlers *rpcNotificationHandlers) handleSubscriptionEvent(ctx context.Context, message []byte) bool {
+	if !bytes.Contains(message, []byte(SubscriptionMethod)) {
+		return false
+	}
+	methodName, id, err := peekRequest(string(message))
+	if err != nil || id != nil || methodName != SubscriptionMethod {
+		return false
+	}
+	return handlers.handleEvent(ctx, string(message))
+}
+
+// handleStreamElement Pass the streamed element to the handler of the call, returns false if the message is not
+// a streamed element.
+func (handlers *rpcNotificationHandlers) handleStreamElement(ctx context.Context, message []byte) bool {
+	if !bytes.Contains(message, []byte(StreamMethod)) {
+		return false
+	}
+	var data struct {
+		Method string `json:"method"`
+		Params struct {
+			Id     any             `json:"id"`
+			Result json.RawMessage `json:"result"`
+		} `json:"params"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(message))
+	decoder.UseNumber()
+	err := decoder.Decode(&data)
+	if err != nil || data.Method != StreamMethod {
+		return false
+	}
+	key := idKey(data.Params.Id)
+	handlers.locker.RLock()
+	method := handlers.streams[key]
+	handlers.locker.RUnlock()
+	if method == nil {
+		logger.Debug("Receive streamed element of unknown request " + key)
+		return true
+	}
+	err = callEventHandler(ctx, method, data.Params.Result)
+	if err != nil {
+		logger.Warning("Handle streamed element of request " + key + " error: " + err.Error())
+	}
+	return true
+}
+
+// addStream Register the handler of the streamed elements of the request.
+func (handlers *rpcNotificationHandlers) addStream(key string, handler *rpcMethod) {
+	handlers.locker.Lock()
+	defer handlers.locker.Unlock()
+	handlers.streams[key] = handler
+}
+
+// removeStream Remove the handler of the streamed elements of the request.
+func (handlers *rpcNotificationHandlers) removeStream(key string) {
+	handlers.locker.Lock()
+	defer handlers.locker.Unlock()
+	delete(handlers.streams, key)
+}
+
+// newRpcNotificationHandlers Create an empty handler set.
+func newRpcNotificationHandlers() *rpcNotificationHandlers {
+	handlers := new(rpcNotificationHandlers)
+	handlers.locker = new(sync.RWMutex)
+	handlers.service = newRpcService("", handlers)
+	handlers.subscriptions = make(map[string]*rpcMethod)
+	handlers.streams = make(map[string]*rpcMethod)
+	return handlers
+}
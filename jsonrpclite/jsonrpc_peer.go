@@ -0,0 +1,458 @@
+package jsonrpclite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+var peerIdSeed int64
+
+// RpcPeer One end of a persistent connection, it serves the incoming calls with its router and makes outgoing
+// calls to the other end, the ids are correlated for each direction separately.
+type RpcPeer struct {
+	id            string //The id of the peer which is unique in this process
+	conn          rpcMessageConn
+	core          *RpcServerEngineCore     //Dispatch the incoming requests, nil if this end does not serve requests
+	serviceName   string                   //The default service of the incoming requests which are not qualified
+	serviceBound  bool                     //The incoming requests are resolved only in serviceName, which the url chose
+	handlers      *rpcNotificationHandlers //Handle the incoming notifications before they are dispatched to the router
+	pushPeers     *rpcPeers                //The peer set of the server engine which accepted the peer, nil on the dialing end
+	subscriptions *rpcSubscriptions        //The subscriptions made by the other end
+	subscribed    []string                 //The ids of the subscriptions made by this end, their handlers are removed on close
+	ctx           context.Context          //The context of the incoming requests, cancelled when the peer is closed
+	cancel        context.CancelFunc
+	locker        *sync.Mutex
+	pending       map[string]*rpcPendingCall
+	err           error         //The reason why the peer was closed
+	done          chan struct{} //Closed when the peer is closed
+}
+
+// run Read the messages until the connection is closed, requests are dispatched and responses are delivered.
+func (peer *RpcPeer) run() {
+	for {
+		message, err := peer.conn.readMessage()
+		if err != nil {
+			peer.shutdown(err)
+			return
+		}
+		peer.receive(message)
+	}
+}
+
+// receive Dispatch the request in its own goroutine or deliver the response to the waiting call.
+func (peer *RpcPeer) receive(message []byte) {
+	if isRequestMessage(message) {
+		//The streamed elements are handled in order before the final response is delivered, and the subscription
+		//events are handled in the order they were sent.
+		if peer.handlers.handleStreamElement(peer.ctx, message) || peer.handlers.handleSubscriptionEvent(peer.ctx, message) {
+			return
+		}
+		go peer.handle(string(message))
+	} else {
+		peer.deliver(message)
+	}
+}
+
+// handle Dispatch the request and write the response back, the events of the subscriptions made by the request
+// are sent after the response.
+func (peer *RpcPeer) handle(requestStr string) {
+	ctx, collector := withSubscriptionCollector(withStreamSink(peer.ctx, peer))
+	defer collector.activateAll()
+	response := peer.dispatch(ctx, requestStr)
+	if response != "" {
+		err := peer.conn.writeMessage([]byte(response))
+		if err != nil {
+			logger.Warning("Write data to peer error: " + err.Error())
+		}
+	}
+}
+
+// dispatch Dispatch the request to the service, the errors are turned into the error response.
+func (peer *RpcPeer) dispatch(ctx context.Context, requestStr string) (response string) {
+	defer func() {
+		var p = any(recover())
+		if p != nil {
+			responseErr, ok := p.(*RpcResponseError)
+			if ok {
+				response = responseErr.response
+			} else {
+				errStr := fmt.Sprintln("Internal JSON-RPC error.") + fmt.Sprintf("%v", p)
+				response = newRpcResponseError(rpcResponse{nil, true, newRpcError(InternalErrorCode, errStr)}).response
+			}
+		}
+	}()
+	if peer.handlers.handle(ctx, requestStr) {
+		return ""
+	}
+	if peer.core == nil {
+		return rejectRequestString(requestStr)
+	}
+	if peer.serviceBound {
+		//The qualified methods of other services are not found, the connection can not reach them.
+		return peer.core.DispatchContext(ctx, peer.serviceName, requestStr)
+	}
+	return peer.core.DispatchQualifiedContext(ctx, peer.serviceName, requestStr)
+}
+
+// call Send the request string and wait for the response, notifications return immediately with empty response.
+func (peer *RpcPeer) call(ctx context.Context, requestStr string) (string, error) {
+	keys, err := messageIdKeys([]byte(requestStr))
+	if err != nil {
+		return "", errors.New("Send request error: " + err.Error())
+	}
+	if len(keys) == 0 {
+		err = peer.conn.writeMessage([]byte(requestStr))
+		if err != nil {
+			return "", errors.New("Send request error: " + err.Error())
+		}
+		return "", nil
+	}
+	streamHandler, _ := ctx.Value(streamHandlerKey).(*rpcMethod)
+	if streamHandler != nil {
+		peer.handlers.addStream(keys[0], streamHandler)
+		defer peer.handlers.removeStream(keys[0])
+	} else if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultRequestTimeout)
+		defer cancel()
+	}
+	hook, _ := ctx.Value(responseHookKey).(func(peer *RpcPeer, response string))
+	pendingCall := &rpcPendingCall{keys, make(chan string, 1), hook}
+	peer.locker.Lock()
+	if peer.err != nil {
+		peer.locker.Unlock()
+		return "", errors.New("Send request error: " + peer.err.Error())
+	}
+	for _, key := range keys {
+		if peer.pending[key] != nil {
+			peer.locker.Unlock()
+			return "", errors.New("Send request error: the request id " + key + " is in use.")
+		}
+	}
+	for _, key := range keys {
+		peer.pending[key] = pendingCall
+	}
+	peer.locker.Unlock()
+	err = peer.conn.writeMessage([]byte(requestStr))
+	if err != nil {
+		peer.removePending(pendingCall)
+		return "", errors.New("Send request error: " + err.Error())
+	}
+	select {
+	case response := <-pendingCall.response:
+		return response, nil
+	case <-ctx.Done():
+		peer.removePending(pendingCall)
+		return "", errors.New("Send request error: " + ctx.Err().Error())
+	case <-peer.done:
+		return "", errors.New("Send request error: " + peer.err.Error())
+	}
+}
+
+// removePending Remove the pending call, returns false if it has been removed.
+func (peer *RpcPeer) removePending(pendingCall *rpcPendingCall) bool {
+	peer.locker.Lock()
+	defer peer.locker.Unlock()
+	if peer.pending[pendingCall.keys[0]] != pendingCall {
+		return false
+	}
+	for _, key := range pendingCall.keys {
+		delete(peer.pending, key)
+	}
+	return true
+}
+
+// deliver Deliver the response to the call which is waiting for it.
+func (peer *RpcPeer) deliver(message []byte) {
+	keys, err := messageIdKeys(message)
+	if err != nil {
+		logger.Warning("Receive invalid response: " + err.Error())
+		return
+	}
+	if len(keys) == 0 && peer.deliverUnidentified(message) {
+		return
+	}
+	for _, key := range keys {
+		peer.locker.Lock()
+		pendingCall := peer.pending[key]
+		peer.locker.Unlock()
+		if pendingCall != nil && peer.removePending(pendingCall) {
+			if pendingCall.hook != nil {
+				pendingCall.hook(peer, string(message))
+			}
+			pendingCall.response <- string(message)
+			return
+		}
+	}
+	logger.Warning("Receive response without waiting request: " + string(message))
+}
+
+// deliverUnidentified Deliver the error response whose id is null, which the other end answers when it can not read
+// the id of the request. It fails the only pending call, or the only pending batch if there are other calls.
+func (peer *RpcPeer) deliverUnidentified(message []byte) bool {
+	peer.locker.Lock()
+	calls := make(map[*rpcPendingCall]bool)
+	var batch *rpcPendingCall
+	batches := 0
+	for _, pendingCall := range peer.pending {
+		if calls[pendingCall] {
+			continue
+		}
+		calls[pendingCall] = true
+		if len(pendingCall.keys) > 1 {
+			batch = pendingCall
+			batches++
+		}
+	}
+	peer.locker.Unlock()
+	var target *rpcPendingCall
+	if len(calls) == 1 {
+		for pendingCall := range calls {
+			target = pendingCall
+		}
+	} else if batches == 1 {
+		target = batch
+	}
+	if target == nil || !peer.removePending(target) {
+		return false
+	}
+	if target.hook != nil {
+		target.hook(peer, string(message))
+	}
+	target.response <- string(message)
+	return true
+}
+
+// shutdown Mark the peer as closed, the incoming requests are cancelled and the pending calls fail.
+func (peer *RpcPeer) shutdown(err error) bool {
+	peer.locker.Lock()
+	defer peer.locker.Unlock()
+	if peer.err != nil {
+		return false
+	}
+	peer.err = err
+	peer.pending = make(map[string]*rpcPendingCall)
+	peer.subscriptions.closeAll()
+	for _, id := range peer.subscribed {
+		peer.handlers.removeSubscription(id)
+	}
+	peer.cancel()
+	close(peer.done)
+	return true
+}
+
+// isClosed Check whether the peer has been closed.
+func (peer *RpcPeer) isClosed() bool {
+	peer.locker.Lock()
+	defer peer.locker.Unlock()
+	return peer.err != nil
+}
+
+// Call Call the method of the service on the other end and unmarshal the result into result, the method is
+// qualified as "Service.Method" unless the serviceName is empty. A *RpcError is returned if the other end
+// responds with an error.
+func (peer *RpcPeer) Call(ctx context.Context, serviceName string, method string, params []any, result any) error {
+	if serviceName != "" {
+		method = serviceName + "." + method
+	}
+	requestData, err := encodeRequestData(nextRequestId(), method, params)
+	if err != nil {
+		return errors.New("Encode request error: " + err.Error())
+	}
+	response, err := peer.call(ctx, string(requestData))
+	if err != nil {
+		return err
+	}
+	return decodeResponseString(response, result)
+}
+
+// Notify Send the notification to the other end, no response will be returned.
+func (peer *RpcPeer) Notify(ctx context.Context, serviceName string, method string, params []any) error {
+	if serviceName != "" {
+		method = serviceName + "." + method
+	}
+	requestData, err := encodeRequestData(nil, method, params)
+	if err != nil {
+		return errors.New("Encode request error: " + err.Error())
+	}
+	_, err = peer.call(ctx, string(requestData))
+	return err
+}
+
+// writeNotification Write the encoded notification to the other end.
+func (peer *RpcPeer) writeNotification(requestData []byte) error {
+	if peer.isClosed() {
+		return errors.New("the connection is closed.")
+	}
+	return peer.conn.writeMessage(requestData)
+}
+
+// sendStreamElement Send the element of the streamed result of the request as a notification.
+func (peer *RpcPeer) sendStreamElement(id any, element any) error {
+	requestData, err := encodeStreamElement(id, element)
+	if err != nil {
+		return err
+	}
+	return peer.writeNotification(requestData)
+}
+
+// Stream Call the method of the service on the other end which returns a channel, the elements are passed to the
+// handler in order as they arrive. The handler is a func taking an optional context.Context and the element.
+func (peer *RpcPeer) Stream(ctx context.Context, serviceName string, method string, params []any, handler any) error {
+	if serviceName != "" {
+		method = serviceName + "." + method
+	}
+	return streamCall(ctx, peer.call, method, params, handler)
+}
+
+// OnNotification Register the handler of the notification method sent by the other end, the handler is a func
+// which can take a context.Context first and return nothing or an error, the other params are decoded from the
+// notification. The notifications without handler are dispatched to the router.
+func (peer *RpcPeer) OnNotification(method string, handler any) {
+	peer.handlers.register(method, handler)
+}
+
+// JoinGroup Join the group of the server engine which accepted the peer, then the notifications to the group are
+// pushed to it. The group is left automatically when the peer is disconnected.
+func (peer *RpcPeer) JoinGroup(group string) {
+	if peer.pushPeers == nil {
+		logger.Warning("The peer " + peer.id + " was not accepted by a server engine, it can not join group " + group)
+		return
+	}
+	peer.pushPeers.join(peer, group)
+}
+
+// LeaveGroup Leave the group of the server engine which accepted the peer.
+func (peer *RpcPeer) LeaveGroup(group string) {
+	if peer.pushPeers != nil {
+		peer.pushPeers.leave(peer, group)
+	}
+}
+
+// Id Get the id of the peer, the server pushes notifications to the peer by it.
+func (peer *RpcPeer) Id() string {
+	return peer.id
+}
+
+// Subscribe Call the subscription method of the service on the other end, the events are passed to the handler
+// which is a func taking an optional context.Context and the event, returns the subscription id.
+func (peer *RpcPeer) Subscribe(ctx context.Context, serviceName string, method string, params []any, handler any) (string, error) {
+	if serviceName != "" {
+		method = serviceName + "." + method
+	}
+	return subscribe(ctx, peer.call, method, params, handler)
+}
+
+// Unsubscribe Cancel the subscription made by Subscribe.
+func (peer *RpcPeer) Unsubscribe(ctx context.Context, serviceName string, subscriptionId string) error {
+	method := UnsubscribeMethod
+	if serviceName != "" {
+		method = serviceName + "." + method
+	}
+	peer.handlers.removeSubscription(subscriptionId)
+	return unsubscribeRemote(ctx, peer.call, method, subscriptionId)
+}
+
+// addSubscribed Register the handler of the subscription made by this end.
+func (peer *RpcPeer) addSubscribed(id string, handler *rpcMethod) {
+	peer.locker.Lock()
+	defer peer.locker.Unlock()
+	if peer.err != nil {
+		return
+	}
+	peer.subscribed = append(peer.subscribed, id)
+	peer.handlers.addSubscription(id, handler)
+}
+
+// RemoteAddr Get the address of the other end.
+func (peer *RpcPeer) RemoteAddr() string {
+	return peer.conn.remoteAddr()
+}
+
+// Done Get the channel which is closed when the peer is closed.
+func (peer *RpcPeer) Done() <-chan struct{} {
+	return peer.done
+}
+
+// Close the peer and the connection, the pending calls fail.
+func (peer *RpcPeer) Close() {
+	if peer.shutdown(errors.New("the connection is closed.")) {
+		err := peer.conn.close()
+		if err != nil {
+			logger.Debug("Close the connection error: " + err.Error())
+		}
+	}
+}
+
+// newBoundRpcPeer Create a peer on the connection of the service chosen by the url, the incoming requests can not
+// reach the other services by qualified methods.
+func newBoundRpcPeer(conn rpcMessageConn, core *RpcServerEngineCore, serviceName string, transportInfo *RpcTransportInfo) *RpcPeer {
+	peer := newRpcPeer(conn, core, serviceName, transportInfo)
+	peer.serviceBound = true
+	return peer
+}
+
+// newRpcPeer Create a peer on the connection, the core dispatches the incoming requests and can be nil.
+func newRpcPeer(conn rpcMessageConn, core *RpcServerEngineCore, serviceName string, transportInfo *RpcTransportInfo) *RpcPeer {
+	peer := new(RpcPeer)
+	peer.id = strconv.FormatInt(atomic.AddInt64(&peerIdSeed, 1), 10)
+	peer.conn = conn
+	peer.core = core
+	peer.serviceName = serviceName
+	peer.handlers = newRpcNotificationHandlers()
+	peer.subscriptions = newRpcSubscriptions()
+	peer.locker = new(sync.Mutex)
+	peer.pending = make(map[string]*rpcPendingCall)
+	peer.done = make(chan struct{})
+	peer.ctx, peer.cancel = context.WithCancel(withPeer(WithTransportInfo(context.Background(), transportInfo), peer))
+	return peer
+}
+
+// newRpcClientPeer Create a peer which serves the incoming requests with the router, the router can be nil.
+// The handlers are shared by the peers of a client engine, nil creates the own ones of the peer.
+func newRpcClientPeer(conn rpcMessageConn, router *rpcRouter, engineName string, handlers *rpcNotificationHandlers) *RpcPeer {
+	var core *RpcServerEngineCore
+	if router != nil {
+		core = new(RpcServerEngineCore)
+		core.SetRouter(router)
+	}
+	transportInfo := &RpcTransportInfo{Engine: engineName, RemoteAddr: conn.remoteAddr()}
+	peer := newRpcPeer(conn, core, "", transportInfo)
+	if handlers != nil {
+		peer.handlers = handlers
+	}
+	go peer.run()
+	return peer
+}
+
+// DialRpcWebSocketPeer Connect to the websocket server engine with the url like ws://localhost:8080/ServiceName,
+// the router serves the calls from the server and can be nil.
+func DialRpcWebSocketPeer(ctx context.Context, url string, router *rpcRouter) (*RpcPeer, error) {
+	conn, err := dialWebSocket(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return newRpcClientPeer(conn, router, "RpcWebSocketPeer", nil), nil
+}
+
+// DialRpcTcpPeer Connect to the tcp server engine with the address like localhost:8080, the router serves the calls
+// from the server and can be nil.
+func DialRpcTcpPeer(ctx context.Context, address string, framing RpcFraming, router *rpcRouter) (*RpcPeer, error) {
+	dialer := new(net.Dialer)
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return NewRpcTcpPeer(conn, framing, router), nil
+}
+
+// NewRpcTcpPeer Create a peer on an established connection, the router serves the calls from the other end and can be nil.
+func NewRpcTcpPeer(conn net.Conn, framing RpcFraming, router *rpcRouter) *RpcPeer {
+	stream := newRpcStreamConn(conn, conn, conn, framing, conn.RemoteAddr().String())
+	return newRpcClientPeer(stream, router, "RpcTcpPeer", nil)
+}
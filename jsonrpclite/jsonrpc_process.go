@@ -0,0 +1,279 @@
+package jsonrpclite
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	processRestartDelay    = time.Second      //The delay before the first restart of a crashed child process
+	processRestartMaxDelay = 30 * time.Second //The cap of the restart delay which doubles on each crash
+	processMaxRestarts     = 10               //The count of crashes in a row before the engine gives up
+	processStableTime      = time.Minute      //The running time after which the crash is not counted in a row
+	processExitTimeout     = 3 * time.Second  //The time for the child process to exit by itself before it is killed
+)
+
+// RpcProcessCommand The command of a child process which speaks JSON-RPC on its stdin and stdout.
+type RpcProcessCommand struct {
+	Path string   //The path of the executable
+	Args []string //The arguments without the executable
+	Env  []string //The environment as "key=value", nil inherits the environment of this process
+	Dir  string   //The working directory, empty uses the one of this process
+}
+
+// The child process and its connection.
+type rpcChildProcess struct {
+	cmd     *exec.Cmd
+	peer    *RpcPeer
+	started time.Time
+	exited  chan struct{} //Closed when the process has exited
+}
+
+// A client engine which launches a child process and talks to it with Content-Length framing over stdin and stdout.
+type rpcProcessClientEngine struct {
+	command   RpcProcessCommand
+	locker    *sync.Mutex
+	child     *rpcChildProcess
+	closed    bool
+	crashes   int                      //The count of crashes in a row
+	restartAt time.Time                //The child process is not started before it after a crash
+	err       error                    //Set when the child process crashed too many times, the requests fail with it
+	handlers  *rpcNotificationHandlers //Handle the notifications sent by the child process, kept across restarts
+}
+
+// GetName Get the engine name.
+func (engine *rpcProcessClientEngine) GetName() string {
+	return "RpcProcessClientEngine"
+}
+
+// startChild Launch the child process, the caller must hold the locker.
+func (engine *rpcProcessClientEngine) startChild() (*rpcChildProcess, error) {
+	cmd := exec.Command(engine.command.Path, engine.command.Args...)
+	cmd.Env = engine.command.Env
+	cmd.Dir = engine.command.Dir
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	name := engine.command.Path + "[" + strconv.Itoa(cmd.Process.Pid) + "]"
+	logger.Info("Child process " + name + " started.")
+	stream := newRpcStreamConn(stdout, stdin, stdin, ContentLengthFraming, name)
+	peer := newRpcClientPeer(stream, nil, engine.GetName(), engine.handlers)
+	child := &rpcChildProcess{cmd, peer, time.Now(), make(chan struct{})}
+	stderrDone := make(chan struct{})
+	go func() {
+		defer close(stderrDone)
+		forwardStderr(name, stderr)
+	}()
+	go engine.monitor(child, name, stderrDone)
+	return child, nil
+}
+
+// forwardStderr Write the lines of stderr of the child process to the logger.
+func forwardStderr(name string, stderr io.Reader) {
+	scanner := bufio.NewScanner(stderr)
+	scanner.Buffer(make([]byte, 4096), rpcMaxMessageSize)
+	for scanner.Scan() {
+		logger.Info(name + ": " + scanner.Text())
+	}
+}
+
+// monitor Wait for the child process to exit and restart it if it was not closed by the engine. The restart delay
+// doubles on each crash in a row, the engine gives up after processMaxRestarts crashes.
+func (engine *rpcProcessClientEngine) monitor(child *rpcChildProcess, name string, stderrDone chan struct{}) {
+	//All reads from the pipes must be completed before waiting for the process.
+	<-child.peer.Done()
+	<-stderrDone
+	err := child.cmd.Wait()
+	close(child.exited)
+	engine.locker.Lock()
+	defer engine.locker.Unlock()
+	current := engine.child == child
+	if current {
+		engine.child = nil
+	}
+	if engine.closed {
+		return
+	}
+	//The crash is counted even if a newer child has been started, so the requests can not bypass the backoff.
+	if time.Since(child.started) >= processStableTime {
+		engine.crashes = 0
+	}
+	engine.crashes++
+	reason := "exited"
+	if err != nil {
+		reason = "crashed: " + err.Error()
+	}
+	if engine.crashes > processMaxRestarts {
+		engine.err = errors.New("the child process " + engine.command.Path + " " + reason + ", it has been restarted " +
+			strconv.Itoa(processMaxRestarts) + " times in a row.")
+		logger.Error("Child process " + name + " " + reason + ", it will not be restarted again.")
+		return
+	}
+	delay := processRestartDelay
+	for i := 1; i < engine.crashes && delay < processRestartMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > processRestartMaxDelay {
+		delay = processRestartMaxDelay
+	}
+	engine.restartAt = time.Now().Add(delay)
+	if !current {
+		return
+	}
+	logger.Warning("Child process " + name + " " + reason + ", it will be restarted in " + delay.String() + ".")
+	time.AfterFunc(delay, func() {
+		engine.locker.Lock()
+		defer engine.locker.Unlock()
+		if engine.closed || engine.child != nil {
+			return
+		}
+		child, err := engine.startChild()
+		if err != nil {
+			logger.Error("Restart child process " + engine.command.Path + " error: " + err.Error())
+			return
+		}
+		engine.child = child
+	})
+}
+
+// getPeer Get the connection to the child process, the process is started if it is not running and it is not
+// waiting for the restart delay after a crash.
+func (engine *rpcProcessClientEngine) getPeer() (*RpcPeer, error) {
+	engine.locker.Lock()
+	defer engine.locker.Unlock()
+	if engine.closed {
+		return nil, errors.New("the engine has been closed.")
+	}
+	if engine.err != nil {
+		return nil, engine.err
+	}
+	if engine.child != nil && !engine.child.peer.isClosed() {
+		return engine.child.peer, nil
+	}
+	wait := time.Until(engine.restartAt)
+	if wait > 0 {
+		return nil, errors.New("the child process " + engine.command.Path + " is waiting to restart in " +
+			wait.Round(time.Millisecond).String() + ".")
+	}
+	child, err := engine.startChild()
+	if err != nil {
+		return nil, err
+	}
+	engine.child = child
+	return child.peer, nil
+}
+
+// ProcessData Send the rpc request data to the child process.
+func (engine *rpcProcessClientEngine) ProcessData(serviceName string, method string, params []any) string {
+	requestData, err := encodeRequestData(nextRequestId(), method, params)
+	if err == nil {
+		return engine.ProcessString(serviceName, string(requestData))
+	} else {
+		var sendErr any = errors.New("Send request error: " + err.Error())
+		panic(sendErr)
+	}
+}
+
+// ProcessString Send the rpc request string to the child process.
+func (engine *rpcProcessClientEngine) ProcessString(serviceName string, requestStr string) string {
+	response, err := engine.ProcessStringContext(context.Background(), serviceName, requestStr)
+	if err == nil {
+		return response
+	} else {
+		var sendErr any = err
+		panic(sendErr)
+	}
+}
+
+// ProcessStringContext Send the rpc request string to the child process, the methods are qualified as
+// "Service.Method" unless the serviceName is empty.
+func (engine *rpcProcessClientEngine) ProcessStringContext(ctx context.Context, serviceName string, requestStr string) (string, error) {
+	requestStr, err := qualifyRequestString(serviceName, requestStr)
+	if err != nil {
+		return "", errors.New("Send request error: " + err.Error())
+	}
+	peer, err := engine.getPeer()
+	if err != nil {
+		return "", errors.New("Send request error: " + err.Error())
+	}
+	return peer.call(ctx, requestStr)
+}
+
+// Close the stdin of the child process and kill it if it does not exit in time.
+func (engine *rpcProcessClientEngine) Close() {
+	engine.locker.Lock()
+	engine.closed = true
+	child := engine.child
+	engine.locker.Unlock()
+	if child == nil {
+		return
+	}
+	child.peer.Close()
+	select {
+	case <-child.exited:
+	case <-time.After(processExitTimeout):
+		logger.Warning("Child process " + engine.command.Path + " does not exit in time, it will be killed.")
+		err := child.cmd.Process.Kill()
+		if err != nil {
+			logger.Warning("Kill child process " + engine.command.Path + " error: " + err.Error())
+		}
+		<-child.exited
+	}
+}
+
+// OnNotification Register the handler of the notification method sent by the child process, see RpcPeer.OnNotification.
+func (engine *rpcProcessClientEngine) OnNotification(method string, handler any) {
+	engine.handlers.register(method, handler)
+}
+
+// Subscribe Call the subscription method of the service, see RpcPeer.Subscribe.
+func (engine *rpcProcessClientEngine) Subscribe(ctx context.Context, serviceName string, method string, params []any, handler any) (string, error) {
+	return subscribe(ctx, func(ctx context.Context, requestStr string) (string, error) {
+		return engine.ProcessStringContext(ctx, serviceName, requestStr)
+	}, method, params, handler)
+}
+
+// Unsubscribe Cancel the subscription made by Subscribe.
+func (engine *rpcProcessClientEngine) Unsubscribe(ctx context.Context, serviceName string, subscriptionId string) error {
+	engine.handlers.removeSubscription(subscriptionId)
+	return unsubscribeRemote(ctx, func(ctx context.Context, requestStr string) (string, error) {
+		return engine.ProcessStringContext(ctx, serviceName, requestStr)
+	}, UnsubscribeMethod, subscriptionId)
+}
+
+// Stream Call the method of the service which returns a channel, see RpcPeer.Stream.
+func (engine *rpcProcessClientEngine) Stream(ctx context.Context, serviceName string, method string, params []any, handler any) error {
+	return streamCall(ctx, func(ctx context.Context, requestStr string) (string, error) {
+		return engine.ProcessStringContext(ctx, serviceName, requestStr)
+	}, method, params, handler)
+}
+
+// NewRpcProcessClientEngine Create a client engine which launches the command and talks to it over stdin and stdout,
+// the child process is started on the first request and restarted if it crashes. The requests fail after the child
+// process crashed processMaxRestarts times in a row.
+func NewRpcProcessClientEngine(command RpcProcessCommand) RpcClientEngine {
+	engine := new(rpcProcessClientEngine)
+	engine.command = command
+	engine.locker = new(sync.Mutex)
+	engine.handlers = newRpcNotificationHandlers()
+	return engine
+}
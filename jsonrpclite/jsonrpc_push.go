@@ -0,0 +1,184 @@
+package jsonrpclite
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// pushWriteTimeout The time for writing a pushed notification to the peers, the peers which have not been written
+// in time are dropped so that a stalled peer does not block the others.
+const pushWriteTimeout = 5 * time.Second
+
+// The peers connected to a server engine, the engine pushes notifications to them by peer id, by group or to all.
+type rpcPeers struct {
+	locker *sync.Mutex
+	peers  map[string]*RpcPeer            //Peers by id
+	groups map[string]map[string]*RpcPeer //Peers by group name and id
+}
+
+// add Track the peer, it can join groups afterwards.
+func (peers *rpcPeers) add(peer *RpcPeer) {
+	peers.locker.Lock()
+	defer peers.locker.Unlock()
+	peers.peers[peer.id] = peer
+	peer.pushPeers = peers
+}
+
+// remove Stop tracking the peer and remove it from all groups.
+func (peers *rpcPeers) remove(peer *RpcPeer) {
+	peers.locker.Lock()
+	defer peers.locker.Unlock()
+	delete(peers.peers, peer.id)
+	for group, members := range peers.groups {
+		delete(members, peer.id)
+		if len(members) == 0 {
+			delete(peers.groups, group)
+		}
+	}
+}
+
+// join Add the tracked peer into the group.
+func (peers *rpcPeers) join(peer *RpcPeer, group string) {
+	peers.locker.Lock()
+	defer peers.locker.Unlock()
+	if peers.peers[peer.id] != peer {
+		return
+	}
+	members := peers.groups[group]
+	if members == nil {
+		members = make(map[string]*RpcPeer)
+		peers.groups[group] = members
+	}
+	members[peer.id] = peer
+}
+
+// leave Remove the peer from the group.
+func (peers *rpcPeers) leave(peer *RpcPeer, group string) {
+	peers.locker.Lock()
+	defer peers.locker.Unlock()
+	members := peers.groups[group]
+	delete(members, peer.id)
+	if len(members) == 0 {
+		delete(peers.groups, group)
+	}
+}
+
+// snapshot Copy the peers so that they can be written without holding the locker.
+func snapshot(members map[string]*RpcPeer) []*RpcPeer {
+	result := make([]*RpcPeer, 0, len(members))
+	for _, peer := range members {
+		result = append(result, peer)
+	}
+	return result
+}
+
+// closeAll Close all the tracked peers.
+func (peers *rpcPeers) closeAll() {
+	peers.locker.Lock()
+	closing := snapshot(peers.peers)
+	peers.peers = make(map[string]*RpcPeer)
+	peers.groups = make(map[string]map[string]*RpcPeer)
+	peers.locker.Unlock()
+	for _, peer := range closing {
+		peer.Close()
+	}
+}
+
+// drop Stop tracking the peer which can not be written and close it in background, closing the stalled connection
+// may take a while.
+func (peers *rpcPeers) drop(peer *RpcPeer) {
+	peers.remove(peer)
+	go peer.Close()
+}
+
+// The result of writing a pushed notification to a peer.
+type rpcPushResult struct {
+	peer *RpcPeer
+	err  error
+}
+
+// push Send the notification to the peers in parallel, returns the count of peers which it was written to.
+// The peers which fail or are not written in pushWriteTimeout are dropped.
+func (peers *rpcPeers) push(targets []*RpcPeer, method string, params []any) (int, error) {
+	requestData, err := encodeRequestData(nil, method, params)
+	if err != nil {
+		return 0, errors.New("Encode notification error: " + err.Error())
+	}
+	//The results are buffered so that the writes which time out do not block after the peer is dropped.
+	results := make(chan rpcPushResult, len(targets))
+	pending := make(map[*RpcPeer]bool, len(targets))
+	for _, peer := range targets {
+		pending[peer] = true
+		go func(peer *RpcPeer) {
+			results <- rpcPushResult{peer, peer.writeNotification(requestData)}
+		}(peer)
+	}
+	timer := time.NewTimer(pushWriteTimeout)
+	defer timer.Stop()
+	count := 0
+	for len(pending) > 0 {
+		select {
+		case result := <-results:
+			delete(pending, result.peer)
+			if result.err != nil {
+				logger.Warning("Push notification to peer " + result.peer.id + " error: " + result.err.Error() +
+					", it will be dropped.")
+				peers.drop(result.peer)
+				continue
+			}
+			count++
+		case <-timer.C:
+			for peer := range pending {
+				logger.Warning("Push notification to peer " + peer.id + " timed out, it will be dropped.")
+				peers.drop(peer)
+			}
+			return count, nil
+		}
+	}
+	return count, nil
+}
+
+// NotifyPeer Send the notification to the connected peer with the id, the peer is dropped if it fails or is not
+// written in pushWriteTimeout.
+func (peers *rpcPeers) NotifyPeer(peerId string, method string, params []any) error {
+	peers.locker.Lock()
+	peer := peers.peers[peerId]
+	peers.locker.Unlock()
+	if peer == nil {
+		return errors.New("The peer " + peerId + " is not connected.")
+	}
+	count, err := peers.push([]*RpcPeer{peer}, method, params)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New("Push notification to peer " + peerId + " failed, it has been dropped.")
+	}
+	return nil
+}
+
+// NotifyGroup Send the notification to the peers in the group, returns the count of peers which it was written to.
+func (peers *rpcPeers) NotifyGroup(group string, method string, params []any) (int, error) {
+	peers.locker.Lock()
+	targets := snapshot(peers.groups[group])
+	peers.locker.Unlock()
+	return peers.push(targets, method, params)
+}
+
+// Broadcast Send the notification to all connected peers, returns the count of peers which it was written to.
+func (peers *rpcPeers) Broadcast(method string, params []any) (int, error) {
+	peers.locker.Lock()
+	targets := snapshot(peers.peers)
+	peers.locker.Unlock()
+	return peers.push(targets, method, params)
+}
+
+// newRpcPeers Create an empty peer set.
+func newRpcPeers() *rpcPeers {
+	peers := new(rpcPeers)
+	peers.locker = new(sync.Mutex)
+	peers.peers = make(map[string]*RpcPeer)
+	peers.groups = make(map[string]map[string]*RpcPeer)
+	return peers
+}
@@ -8,12 +8,15 @@ type rpcParam struct {
 }
 
 type rpcRequest struct {
-	id     any        //The id of the request
-	method string     //The method name of the request
-	params []rpcParam // params stored in this request
+	id      any         //The id of the request
+	method  string      //The method name of the request
+	params  []rpcParam  // params stored in this request
+	err     *RpcError   //The error occurred while decoding, the request will not be invoked if it is not nil
+	invalid bool        //True when it is not a valid Request object, which must be answered even without id
+	service *rpcService //The service which the request is dispatched to
 }
 
 // isNotification Check whether the request is a notification.
 func (request rpcRequest) isNotification() bool {
-	return request.id == nil
+	return request.id == nil && !request.invalid
 }
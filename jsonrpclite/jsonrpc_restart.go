@@ -0,0 +1,243 @@
+package jsonrpclite
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// ListenersEnv The environment variable which lists the keys of the listeners handed to the restarted process,
+	// the file descriptors start from 3 in the same order.
+	ListenersEnv = "JSONRPCLITE_LISTENERS"
+	// ListenersReadyEnv The environment variable of the file descriptor which the restarted process reports to when
+	// it serves on all the listeners handed to it.
+	ListenersReadyEnv = "JSONRPCLITE_LISTENERS_READY"
+)
+
+// The separator of the listener keys in ListenersEnv.
+const listenerKeySeparator = ";"
+
+// RpcHandoffEngine A server engine whose listener can be handed to a new process, so it restarts without downtime.
+type RpcHandoffEngine interface {
+	RpcListenerEngine
+	// ListenerFile Get the key which the new process takes the listener over by and a duplicate of the listener file.
+	ListenerFile() (string, *os.File, error)
+}
+
+// rpcHandoffListener The engine which keeps the socket file of its unix listener when the listener has been handed to
+// the new process and is closed.
+type rpcHandoffListener interface {
+	handOff()
+}
+
+// The listeners handed over by the process which restarted this one.
+var inheritedListeners struct {
+	once   sync.Once
+	locker sync.Mutex
+	files  map[string]*os.File //The files of the listeners which are not taken yet
+	ready  *os.File            //Report to the old process when all listeners are served
+	unix   []*net.UnixListener //The unix listeners taken which the old process still owns the socket files of
+}
+
+// loadInheritedListeners Take the file descriptors listed in ListenersEnv once, the variables are removed so the
+// child processes of this one do not see them.
+func loadInheritedListeners() {
+	inheritedListeners.once.Do(func() {
+		keys := os.Getenv(ListenersEnv)
+		readyFd := os.Getenv(ListenersReadyEnv)
+		_ = os.Unsetenv(ListenersEnv)
+		_ = os.Unsetenv(ListenersReadyEnv)
+		if keys == "" {
+			return
+		}
+		inheritedListeners.files = make(map[string]*os.File)
+		for i, key := range strings.Split(keys, listenerKeySeparator) {
+			inheritedListeners.files[key] = os.NewFile(uintptr(systemdFdStart+i), key)
+		}
+		fd, err := strconv.Atoi(readyFd)
+		if err == nil {
+			inheritedListeners.ready = os.NewFile(uintptr(fd), ListenersReadyEnv)
+		}
+		logger.Info("Take over " + strconv.Itoa(len(inheritedListeners.files)) + " listeners from the old process.")
+	})
+}
+
+// hasInheritedListener Check whether the listener with the key is handed over and not taken yet.
+func hasInheritedListener(key string) bool {
+	loadInheritedListeners()
+	inheritedListeners.locker.Lock()
+	defer inheritedListeners.locker.Unlock()
+	return inheritedListeners.files[key] != nil
+}
+
+// takeInheritedListener Take the listener with the key which is handed over, nil if there is not one.
+func takeInheritedListener(key string) (net.Listener, error) {
+	loadInheritedListeners()
+	inheritedListeners.locker.Lock()
+	defer inheritedListeners.locker.Unlock()
+	file := inheritedListeners.files[key]
+	if file == nil {
+		return nil, nil
+	}
+	delete(inheritedListeners.files, key)
+	defer file.Close()
+	listener, err := net.FileListener(file)
+	if unixListener, ok := listener.(*net.UnixListener); ok {
+		//The old process owns the socket file until this one is ready, it keeps serving on it if this one fails.
+		unixListener.SetUnlinkOnClose(false)
+		inheritedListeners.unix = append(inheritedListeners.unix, unixListener)
+	}
+	return listener, err
+}
+
+// reportInheritedReady Tell the old process that this one serves on all listeners handed over, so it can drain.
+func reportInheritedReady() {
+	inheritedListeners.locker.Lock()
+	defer inheritedListeners.locker.Unlock()
+	if inheritedListeners.ready == nil || len(inheritedListeners.files) > 0 {
+		return
+	}
+	_, err := inheritedListeners.ready.Write([]byte("ready"))
+	if err != nil {
+		logger.Warning("Report to the old process error: " + err.Error())
+	} else {
+		//This process owns the socket files now.
+		for _, listener := range inheritedListeners.unix {
+			listener.SetUnlinkOnClose(true)
+		}
+	}
+	inheritedListeners.unix = nil
+	_ = inheritedListeners.ready.Close()
+	inheritedListeners.ready = nil
+}
+
+// listenerFile Get a duplicate of the listener file which can be handed to a new process.
+func listenerFile(listener net.Listener) (*os.File, error) {
+	switch listener := listener.(type) {
+	case *net.TCPListener:
+		return listener.File()
+	case *net.UnixListener:
+		return listener.File()
+	case nil:
+		return nil, errors.New("The engine is not listening.")
+	default:
+		return nil, errors.New("The listener can not be handed to another process.")
+	}
+}
+
+// keepSocketFile Keep the socket file when the unix listener is closed, the new process serves on it.
+func keepSocketFile(listener net.Listener) {
+	if unixListener, ok := listener.(*net.UnixListener); ok {
+		unixListener.SetUnlinkOnClose(false)
+	}
+}
+
+// processEnv Get the environment of this process without the variables of the last restart.
+func processEnv() []string {
+	env := make([]string, 0, len(os.Environ())+2)
+	for _, item := range os.Environ() {
+		if strings.HasPrefix(item, ListenersEnv+"=") || strings.HasPrefix(item, ListenersReadyEnv+"=") {
+			continue
+		}
+		env = append(env, item)
+	}
+	return env
+}
+
+// RestartServers Start the executable of this process again with the same arguments and hand the listeners of the
+// servers to it, the new process takes them over when its engines listen on the same addresses. Once it serves on
+// all of them, the servers here are shut down gracefully until the ctx is done, and this process should exit.
+// If the new process fails or does not get ready before the ctx is done, it is killed and the servers keep serving.
+// It is usually called on SIGHUP.
+func RestartServers(ctx context.Context, servers ...*rpcServer) error {
+	keys := make([]string, 0, len(servers))
+	files := make([]*os.File, 0, len(servers)+1)
+	defer func() {
+		for _, file := range files {
+			_ = file.Close()
+		}
+	}()
+	for _, server := range servers {
+		engine, ok := server.engine.(RpcHandoffEngine)
+		if !ok {
+			return errors.New("The engine " + server.engine.GetName() + " can not hand its listener to another process.")
+		}
+		key, file, err := engine.ListenerFile()
+		if err != nil {
+			return errors.New("Get the listener of engine " + engine.GetName() + " error: " + err.Error())
+		}
+		keys = append(keys, key)
+		files = append(files, file)
+	}
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyReader.Close()
+	files = append(files, readyWriter)
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(processEnv(),
+		ListenersEnv+"="+strings.Join(keys, listenerKeySeparator),
+		ListenersReadyEnv+"="+strconv.Itoa(systemdFdStart+len(keys)))
+	err = cmd.Start()
+	for _, file := range files[:len(keys)] {
+		restoreNonblock(file)
+	}
+	if err != nil {
+		return errors.New("Start the new process error: " + err.Error())
+	}
+	//The new process holds the files now, the ready pipe gets EOF if it exits.
+	_ = readyWriter.Close()
+	logger.Info("Start the new process " + strconv.Itoa(cmd.Process.Pid) + " with " + strconv.Itoa(len(keys)) + " listeners.")
+	ready := make(chan error, 1)
+	go func() {
+		message, err := io.ReadAll(readyReader)
+		if err == nil && string(message) != "ready" {
+			err = errors.New("The new process exited before it was ready.")
+		}
+		ready <- err
+	}()
+	select {
+	case err = <-ready:
+	case <-ctx.Done():
+		err = errors.New("The new process is not ready in time: " + ctx.Err().Error())
+	}
+	if err != nil {
+		_ = cmd.Process.Kill()
+		go cmd.Wait()
+		return err
+	}
+	logger.Info("The new process " + strconv.Itoa(cmd.Process.Pid) + " is ready, shutdown the servers.")
+	_ = cmd.Process.Release()
+	var shutdownErr error
+	for _, server := range servers {
+		if engine, ok := server.engine.(rpcHandoffListener); ok {
+			engine.handOff()
+		}
+		err = server.Shutdown(ctx)
+		if err != nil && shutdownErr == nil {
+			shutdownErr = err
+		}
+	}
+	return shutdownErr
+}
+
+// Restart Hand the listener to a new process of the same executable and shutdown gracefully, see RestartServers.
+func (server *rpcServer) Restart(ctx context.Context) error {
+	return RestartServers(ctx, server)
+}
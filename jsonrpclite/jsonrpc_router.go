@@ -1,13 +1,19 @@
 package jsonrpclite
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"reflect"
+	"strconv"
+	"sync"
+	"time"
 )
 
 type rpcRouter struct {
-	services map[string]*rpcService //Services in router
+	services         map[string]*rpcService //Services in router
+	batchConcurrency int                    //The max count of batch entries invoked in parallel, 0 or 1 invokes them one by one
+	interceptors     []RpcInterceptor       //Interceptors for all services
 }
 
 // NewRpcRouter Create a new rpc router.
@@ -15,43 +21,95 @@ func NewRpcRouter() *rpcRouter {
 	return new(rpcRouter)
 }
 
-// dispatchRequests Dispatch request/s to services and get the response/s
-func (router *rpcRouter) dispatchRequests(serviceName string, requests []rpcRequest) []rpcResponse {
+// dispatchRequests Dispatch request/s to their services and get the response/s, every request gets its own response.
+func (router *rpcRouter) dispatchRequests(ctx context.Context, requests []rpcRequest) []rpcResponse {
+	if router.batchConcurrency > 1 && len(requests) > 1 {
+		return router.dispatchConcurrently(ctx, requests)
+	}
+	var responses = make([]rpcResponse, 0, len(requests))
+	for i := 0; i < len(requests); i++ {
+		request := requests[i]
+		response := router.invokeRequest(ctx, request)
+		if !request.isNotification() {
+			responses = append(responses, response)
+		}
+	}
+	return responses
+}
+
+// dispatchConcurrently Invoke the batch entries in parallel, the responses keep the order of the requests.
+func (router *rpcRouter) dispatchConcurrently(ctx context.Context, requests []rpcRequest) []rpcResponse {
+	results := make([]rpcResponse, len(requests))
+	semaphore := make(chan struct{}, router.batchConcurrency)
+	waitGroup := new(sync.WaitGroup)
+	for i := 0; i < len(requests); i++ {
+		request := requests[i]
+		semaphore <- struct{}{}
+		if request.isNotification() {
+			//Notification is fire-and-forget, it must not be cancelled when the batch is answered.
+			notificationCtx, finish := trackDetached(detachContext(ctx))
+			go func() {
+				defer func() {
+					finish()
+					<-semaphore
+				}()
+				router.invokeRequest(notificationCtx, request)
+			}()
+			continue
+		}
+		waitGroup.Add(1)
+		go func(index int) {
+			defer func() {
+				<-semaphore
+				waitGroup.Done()
+			}()
+			results[index] = router.invokeRequest(ctx, requests[index])
+		}(i)
+	}
+	waitGroup.Wait()
+	var responses = make([]rpcResponse, 0, len(requests))
+	for i := 0; i < len(requests); i++ {
+		if !requests[i].isNotification() {
+			responses = append(responses, results[i])
+		}
+	}
+	return responses
+}
+
+// invokeRequest Invoke a single request, errors and panics are turned into the error response of the request.
+func (router *rpcRouter) invokeRequest(ctx context.Context, request rpcRequest) (response rpcResponse) {
+	if request.err != nil {
+		return rpcResponse{request.id, true, request.err}
+	}
+	service := request.service
 	defer func() {
 		var p = any(recover())
 		if p != nil {
 			errStr := fmt.Sprintln("Internal JSON-RPC error.") + fmt.Sprintf("%v", p)
-			err := newRpcError(-32603, errStr)
-			response := rpcResponse{-1, true, err}
-			var responseErr any = newRpcResponseError(response)
-			panic(responseErr)
+			response = rpcResponse{request.id, true, newRpcError(InternalErrorCode, errStr)}
 		}
 	}()
-	rpcService := router.getService(serviceName)
-	if rpcService == nil {
-		var err any = errors.New("Service " + serviceName + " does not exist.")
-		panic(err)
-	} else {
-		if len(requests) > 1 {
-			var responses = make([]rpcResponse, 0)
-			for i := 0; i < len(requests); i++ {
-				request := requests[i]
-				response := rpcService.invoke(request)
-				if !request.isNotification() {
-					responses = append(responses, rpcResponse{request.id, false, response.result})
-				}
-			}
-			return responses
-		} else {
-			var responses = make([]rpcResponse, 0)
-			request := requests[0]
-			response := rpcService.invoke(request)
-			if !request.isNotification() {
-				responses = append(responses, response)
-			}
-			return responses
-		}
+	requestInfo := &RpcRequestInfo{ServiceName: service.name, Method: request.method, Id: request.id}
+	invocation := &RpcInvocation{
+		Context:     withRequestInfo(ctx, requestInfo),
+		ServiceName: service.name,
+		MethodName:  request.method,
+		RequestId:   request.id,
+		Params:      make([]any, len(request.params)),
 	}
+	for i := 0; i < len(request.params); i++ {
+		invocation.Params[i] = request.params[i].value
+	}
+	method := service.methods[request.method]
+	interceptors := make([]RpcInterceptor, 0, len(router.interceptors)+len(service.interceptors)+len(method.interceptors))
+	interceptors = append(interceptors, router.interceptors...)
+	interceptors = append(interceptors, service.interceptors...)
+	interceptors = append(interceptors, method.interceptors...)
+	result, err := chainInterceptors(interceptors, service.invoke)(invocation)
+	if err != nil {
+		return rpcResponse{request.id, true, toRpcError(err)}
+	}
+	return rpcResponse{request.id, false, result}
 }
 
 //Get the service by service name
@@ -85,5 +143,100 @@ func (router *rpcRouter) RegisterService(serviceName string, serviceInstance any
 	if router.services == nil {
 		router.services = make(map[string]*rpcService)
 	}
+	//Every service can cancel the subscriptions made by its methods.
+	s.addMethod(newFuncMethod(UnsubscribeMethod, instanceType, unsubscribe))
 	router.services[serviceName] = s
 }
+
+// resolveService Get the service of the method which is qualified as "Service.Method", or the defaultService if the
+// method is not qualified. A service without methods is returned if neither exists, so the request gets -32601.
+func (router *rpcRouter) resolveService(method string, defaultService string) *rpcService {
+	service := router.findServiceOfMethod(method)
+	if service == nil && defaultService != "" {
+		service = router.getService(defaultService)
+	}
+	if service == nil {
+		service = newRpcService("", nil)
+	}
+	return service
+}
+
+// findServiceOfMethod Find the service of the method which is qualified as "Service.Method".
+func (router *rpcRouter) findServiceOfMethod(method string) *rpcService {
+	for i := 0; i < len(method); i++ {
+		if method[i] == '.' {
+			service := router.getService(method[:i])
+			if service != nil {
+				return service
+			}
+		}
+	}
+	return nil
+}
+
+// mustGetService Get the registered service by service name, panic if not exists.
+func (router *rpcRouter) mustGetService(serviceName string) *rpcService {
+	service := router.getService(serviceName)
+	if service == nil {
+		var err any = errors.New("Service " + serviceName + " does not exist.")
+		panic(err)
+	}
+	return service
+}
+
+// mustGetMethod Get the registered method by service name and method name, panic if not exists.
+func (router *rpcRouter) mustGetMethod(serviceName string, methodName string) *rpcMethod {
+	method := router.mustGetService(serviceName).methods[methodName]
+	if method == nil {
+		var err any = errors.New("Method " + serviceName + "." + methodName + " does not exist.")
+		panic(err)
+	}
+	return method
+}
+
+// SetParamNames Declare the param names of a registered method, then the by-name params can be bound onto it.
+func (router *rpcRouter) SetParamNames(serviceName string, methodName string, names ...string) {
+	router.mustGetMethod(serviceName, methodName).setParamNames(names)
+}
+
+// SetMethodSafe Mark a registered method as safe, which only reads, so it can be called by http GET and its responses
+// may be cached by the clients and proxies for maxAge, 0 makes them revalidate by the ETag each time.
+func (router *rpcRouter) SetMethodSafe(serviceName string, methodName string, maxAge time.Duration) {
+	cacheControl := "no-cache"
+	if maxAge > 0 {
+		cacheControl = "public, max-age=" + strconv.Itoa(int(maxAge/time.Second))
+	}
+	router.mustGetMethod(serviceName, methodName).allowGet(cacheControl)
+}
+
+// SetMethodIdempotent Mark a registered method as idempotent, which can be repeated without further effect, so it can
+// be called by http GET, its responses are not shared by proxies and revalidated by the ETag each time.
+func (router *rpcRouter) SetMethodIdempotent(serviceName string, methodName string) {
+	router.mustGetMethod(serviceName, methodName).allowGet("private, no-cache")
+}
+
+// SetBatchConcurrency Set the max count of batch entries invoked in parallel, 0 or 1 invokes them one by one.
+func (router *rpcRouter) SetBatchConcurrency(limit int) {
+	if limit < 0 {
+		var err any = errors.New("The batch concurrency should not be negative.")
+		panic(err)
+	}
+	router.batchConcurrency = limit
+}
+
+// AddInterceptor Add an interceptor for all services, global interceptors run before the service and method ones.
+func (router *rpcRouter) AddInterceptor(interceptor RpcInterceptor) {
+	router.interceptors = append(router.interceptors, interceptor)
+}
+
+// AddServiceInterceptor Add an interceptor for all methods of a registered service.
+func (router *rpcRouter) AddServiceInterceptor(serviceName string, interceptor RpcInterceptor) {
+	service := router.mustGetService(serviceName)
+	service.interceptors = append(service.interceptors, interceptor)
+}
+
+// AddMethodInterceptor Add an interceptor for a registered method, method interceptors run closest to the method.
+func (router *rpcRouter) AddMethodInterceptor(serviceName string, methodName string, interceptor RpcInterceptor) {
+	method := router.mustGetMethod(serviceName, methodName)
+	method.interceptors = append(method.interceptors, interceptor)
+}
@@ -1,17 +1,105 @@
 package jsonrpclite
 
+import (
+	"context"
+	"errors"
+	"net"
+)
+
 type rpcServer struct {
 	engine RpcServerEngine
 }
 
-//Start the server with the router.
-func (server *rpcServer) Start(router *rpcRouter) {
-	server.engine.Start(router)
+//Start the server with the router, the error is returned if the engine can not listen.
+func (server *rpcServer) Start(router *rpcRouter) error {
+	logger.Info("Start the server with engine " + server.engine.GetName() + ".")
+	err := server.engine.Start(router)
+	if err != nil {
+		logger.Error("Start the server with engine " + server.engine.GetName() + " error: " + err.Error())
+		return err
+	}
+	reportInheritedReady()
+	return nil
+}
+
+// Addr Get the address which the server listens on, nil if it is not started or the engine does not listen.
+func (server *rpcServer) Addr() net.Addr {
+	engine, ok := server.engine.(RpcListenerEngine)
+	if !ok {
+		return nil
+	}
+	return engine.Addr()
+}
+
+// Done Get the channel which is closed when the engine finishes by itself like the stdio engine on EOF, nil if the
+// engine does not finish by itself.
+func (server *rpcServer) Done() <-chan struct{} {
+	engine, ok := server.engine.(RpcDoneEngine)
+	if !ok {
+		return nil
+	}
+	return engine.Done()
 }
 
 //Stop the server
 func (server *rpcServer) Stop() {
 	server.engine.Stop()
+	logger.Info("The server with engine " + server.engine.GetName() + " stopped.")
+}
+
+// Shutdown Stop the server gracefully: no new requests are taken, the requests in process and the entries of the
+// batches may finish until the ctx is done, then the rest are cancelled through their contexts. The engine which
+// can not stop gracefully is stopped at once.
+func (server *rpcServer) Shutdown(ctx context.Context) error {
+	logger.Info("Shutdown the server with engine " + server.engine.GetName() + ".")
+	engine, ok := server.engine.(RpcShutdownEngine)
+	if !ok {
+		server.Stop()
+		return nil
+	}
+	err := engine.Shutdown(ctx)
+	if err != nil {
+		logger.Warning("The server with engine " + server.engine.GetName() + " stopped before the requests finished: " + err.Error())
+		return err
+	}
+	logger.Info("The server with engine " + server.engine.GetName() + " stopped.")
+	return nil
+}
+
+// pushEngine Get the engine as RpcPushEngine, error if it can not push notifications.
+func (server *rpcServer) pushEngine() (RpcPushEngine, error) {
+	engine, ok := server.engine.(RpcPushEngine)
+	if !ok {
+		return nil, errors.New("The engine " + server.engine.GetName() + " can not push notifications.")
+	}
+	return engine, nil
+}
+
+// NotifyPeer Push the notification to the connected peer, the id is got by RpcPeer.Id().
+func (server *rpcServer) NotifyPeer(peerId string, method string, params []any) error {
+	engine, err := server.pushEngine()
+	if err != nil {
+		return err
+	}
+	return engine.NotifyPeer(peerId, method, params)
+}
+
+// NotifyGroup Push the notification to the peers which joined the group by RpcPeer.JoinGroup.
+func (server *rpcServer) NotifyGroup(group string, method string, params []any) (int, error) {
+	engine, err := server.pushEngine()
+	if err != nil {
+		return 0, err
+	}
+	return engine.NotifyGroup(group, method, params)
+}
+
+// Broadcast Push the notification to all connected peers.
+func (server *rpcServer) Broadcast(method string, params []any) (int, error) {
+	engine, err := server.pushEngine()
+	if err != nil {
+		return 0, err
+	}
+	return engine.Broadcast(method, params)
 }
 
 // NewRpcServer Create a new rpc server with engine.
@@ -3,10 +3,10 @@ package jsonrpclite
 import "errors"
 
 type rpcService struct {
-	name     string                //The name of the service
-	instance any                   //The real instance of the service
-	methods  map[string]*rpcMethod //Methods belong to this service
-
+	name         string                //The name of the service
+	instance     any                   //The real instance of the service
+	methods      map[string]*rpcMethod //Methods belong to this service
+	interceptors []RpcInterceptor      //Interceptors for all methods of this service
 }
 
 // addMethod Add method into the service
@@ -17,22 +17,40 @@ func (service *rpcService) addMethod(method *rpcMethod) {
 	service.methods[method.name] = method
 }
 
-// invoke call method of service by method name and parameter.
-func (service *rpcService) invoke(request rpcRequest) rpcResponse {
+// invoke call method of service by the invocation which has passed the interceptors.
+func (service *rpcService) invoke(invocation *RpcInvocation) (any, error) {
 	if service.methods == nil {
-		var err any = errors.New("Can not find method " + request.method)
+		var err any = errors.New("Can not find method " + invocation.MethodName)
 		panic(err)
 	}
-	paramCount := len(request.params)
-	callParams := make([]any, paramCount+1)
-	callParams[0] = service.instance
-	for i := 0; i < paramCount; i++ {
-		callParams[i+1] = request.params[i].value
+	method := service.methods[invocation.MethodName]
+	paramCount := len(invocation.Params)
+	callParams := make([]any, 0, paramCount+3)
+	callParams = append(callParams, service.instance)
+	if method.hasContext {
+		callParams = append(callParams, invocation.Context)
+	}
+	if method.subscription {
+		subscription, err := startSubscription(invocation.Context, invocation.MethodName)
+		if err != nil {
+			return nil, err
+		}
+		callParams = append(callParams, subscription)
+		callParams = append(callParams, invocation.Params...)
+		_, err = method.call(callParams)
+		if err != nil {
+			subscription.Close()
+			return nil, err
+		}
+		return subscription.Id(), nil
+	}
+	callParams = append(callParams, invocation.Params...)
+	result, err := method.call(callParams)
+	if err == nil && method.stream {
+		//The elements are sent within the interceptors, so they see the whole stream and its final result.
+		return drainStream(invocation.Context, invocation.RequestId, result)
 	}
-	method := service.methods[request.method]
-	result := method.call(callParams)
-	response := rpcResponse{id: request.id, isError: false, result: result}
-	return response
+	return result, err
 }
 
 // newRpcService Create a new rpcService
@@ -0,0 +1,147 @@
+package jsonrpclite
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ShuttingDownCode The server error code of the requests which arrive while the server is shutting down.
+const ShuttingDownCode = -32000
+
+// drainCancelGrace How long the cancelled requests have to write their responses before the connections are closed.
+const drainCancelGrace = time.Second
+
+// RpcShutdownEngine A server engine which can stop gracefully.
+type RpcShutdownEngine interface {
+	RpcServerEngine
+	// Shutdown Stop taking new requests, wait for the requests in process until the ctx is done, then cancel the
+	// rest through their contexts and close the connections.
+	Shutdown(ctx context.Context) error
+}
+
+// The requests in process of a server engine.
+type rpcInFlight struct {
+	locker   sync.Mutex
+	draining bool                          //The new requests are rejected
+	nextId   uint64                        //The id of the next request
+	cancels  map[uint64]context.CancelFunc //Cancel the requests in process
+	idle     chan struct{}                 //Closed when the last request finishes while draining
+}
+
+// track Add the request to the in-flight ones even if draining, the returned func must be called when it finishes.
+func (inFlight *rpcInFlight) track(ctx context.Context) (context.Context, func()) {
+	ctx, finish, _ := inFlight.add(ctx, false)
+	return ctx, finish
+}
+
+// begin Add a new request to the in-flight ones, false if the engine is draining.
+func (inFlight *rpcInFlight) begin(ctx context.Context) (context.Context, func(), bool) {
+	return inFlight.add(ctx, true)
+}
+
+// add Add the request to the in-flight ones, it is rejected if draining and rejectDraining is set. The check and the
+// insert are done under one lock, so drain never misses a request which is accepted.
+func (inFlight *rpcInFlight) add(ctx context.Context, rejectDraining bool) (context.Context, func(), bool) {
+	inFlight.locker.Lock()
+	defer inFlight.locker.Unlock()
+	if rejectDraining && inFlight.draining {
+		return ctx, nil, false
+	}
+	ctx, cancel := context.WithCancel(context.WithValue(ctx, inFlightKey, inFlight))
+	if inFlight.cancels == nil {
+		inFlight.cancels = make(map[uint64]context.CancelFunc)
+	}
+	id := inFlight.nextId
+	inFlight.nextId++
+	inFlight.cancels[id] = cancel
+	return ctx, func() {
+		cancel()
+		inFlight.locker.Lock()
+		defer inFlight.locker.Unlock()
+		delete(inFlight.cancels, id)
+		if len(inFlight.cancels) == 0 && inFlight.idle != nil {
+			close(inFlight.idle)
+			inFlight.idle = nil
+		}
+	}, true
+}
+
+// drain Reject the new requests and wait for the ones in process, they are cancelled when the ctx is done.
+func (inFlight *rpcInFlight) drain(ctx context.Context) error {
+	inFlight.locker.Lock()
+	inFlight.draining = true
+	count := len(inFlight.cancels)
+	if count == 0 {
+		inFlight.locker.Unlock()
+		return nil
+	}
+	if inFlight.idle == nil {
+		inFlight.idle = make(chan struct{})
+	}
+	idle := inFlight.idle
+	inFlight.locker.Unlock()
+	logger.Info("Wait for " + strconv.Itoa(count) + " requests in process.")
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+	}
+	inFlight.locker.Lock()
+	cancels := make([]context.CancelFunc, 0, len(inFlight.cancels))
+	for _, cancel := range inFlight.cancels {
+		cancels = append(cancels, cancel)
+	}
+	inFlight.locker.Unlock()
+	if len(cancels) > 0 {
+		logger.Warning("Cancel " + strconv.Itoa(len(cancels)) + " requests which did not finish in time.")
+	}
+	for _, cancel := range cancels {
+		cancel()
+	}
+	timer := time.NewTimer(drainCancelGrace)
+	defer timer.Stop()
+	select {
+	case <-idle:
+	case <-timer.C:
+	}
+	return ctx.Err()
+}
+
+// resume Take the new requests again after the engine restarts.
+func (inFlight *rpcInFlight) resume() {
+	inFlight.locker.Lock()
+	defer inFlight.locker.Unlock()
+	inFlight.draining = false
+}
+
+// trackDetached Keep the detached request of the batch in the in-flight ones of the engine which dispatched the batch.
+func trackDetached(ctx context.Context) (context.Context, func()) {
+	inFlight, _ := ctx.Value(inFlightKey).(*rpcInFlight)
+	if inFlight == nil {
+		return ctx, func() {}
+	}
+	return inFlight.track(ctx)
+}
+
+// rejectShuttingDown Answer the requests with the shutting down error.
+func rejectShuttingDown(requests []rpcRequest, isBatch bool) string {
+	responses := make([]rpcResponse, 0, len(requests))
+	for _, request := range requests {
+		if !request.isNotification() {
+			err := NewRpcServerError(ShuttingDownCode, "The server is shutting down.", nil)
+			responses = append(responses, rpcResponse{request.id, true, err})
+		}
+	}
+	if len(responses) == 0 {
+		return ""
+	}
+	return string(encodeResponses(responses, isBatch))
+}
+
+// Drain Reject the new requests with ShuttingDownCode and wait for the ones in process, they are cancelled through
+// their contexts when the ctx is done and the error of the ctx is returned. Starting the engine again resumes it.
+func (engine *RpcServerEngineCore) Drain(ctx context.Context) error {
+	return engine.inFlight.drain(ctx)
+}
@@ -0,0 +1,348 @@
+package jsonrpclite
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A server engine which accepts persistent connections, the messages are split by the framing.
+type rpcSocketServerEngine struct {
+	name           string
+	network        string
+	address        string
+	framing        RpcFraming
+	defaultService string            //The service of the requests whose methods are not qualified as "Service.Method"
+	fileMode       os.FileMode       //The file mode of the unix socket, 0 keeps the default one
+	options        *rpcServerOptions //How the engine listens
+	listener       net.Listener
+	*rpcPeers
+	*RpcServerEngineCore
+}
+
+// GetName Get the engine name.
+func (engine *rpcSocketServerEngine) GetName() string {
+	return engine.name
+}
+
+// Start the engine and initialize the router.
+func (engine *rpcSocketServerEngine) Start(router *rpcRouter) error {
+	if engine.listener != nil {
+		logger.Warning("The server of engine already started, will be closed.")
+		engine.Stop()
+	}
+	//The socket file is only managed when the engine creates the listener.
+	socketFile := engine.network == "unix" && !engine.options.injected(engine.network, engine.address)
+	if socketFile {
+		err := removeStaleSocket(engine.address)
+		if err != nil {
+			return errors.New("Listen on " + engine.network + " " + engine.address + " error: " + err.Error())
+		}
+	}
+	var listener net.Listener
+	var err error
+	if socketFile && engine.fileMode != 0 {
+		//The socket is bound with the file mode, it is never reachable with the default permissions.
+		engine.options.key = engine.options.listenerKey(engine.network, engine.address)
+		listener, err = listenUnixMode(engine.address, engine.fileMode)
+	} else {
+		listener, err = engine.options.listen(engine.network, engine.address)
+	}
+	if err != nil {
+		return errors.New("Listen on " + engine.network + " " + engine.address + " error: " + err.Error())
+	}
+	engine.RpcServerEngineCore.SetRouter(router)
+	engine.listener = listener
+	logger.Info("The engine " + engine.GetName() + " listens on " + listener.Addr().String())
+	go engine.accept(listener)
+	return nil
+}
+
+// Addr Get the address which the engine listens on, nil if it is not started.
+func (engine *rpcSocketServerEngine) Addr() net.Addr {
+	return listenerAddr(engine.listener)
+}
+
+// ListenerFile Get the listener which can be handed to a new process, see RestartServers.
+func (engine *rpcSocketServerEngine) ListenerFile() (string, *os.File, error) {
+	file, err := listenerFile(engine.listener)
+	return engine.options.key, file, err
+}
+
+// handOff Keep the socket file of the listener when it is closed, the new process serves on it.
+func (engine *rpcSocketServerEngine) handOff() {
+	keepSocketFile(engine.listener)
+}
+
+// accept Accept the connections until the listener is closed.
+func (engine *rpcSocketServerEngine) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logger.Warning("Accept connection error: " + err.Error())
+			}
+			return
+		}
+		go engine.serve(conn)
+	}
+}
+
+// serve Dispatch the requests of the connection until it is closed.
+func (engine *rpcSocketServerEngine) serve(conn net.Conn) {
+	remoteAddr := conn.RemoteAddr().String()
+	stream := newRpcStreamConn(conn, conn, conn, engine.framing, remoteAddr)
+	transportInfo := &RpcTransportInfo{Engine: engine.GetName(), RemoteAddr: remoteAddr}
+	peer := newRpcPeer(stream, engine.RpcServerEngineCore, engine.defaultService, transportInfo)
+	engine.rpcPeers.add(peer)
+	defer engine.rpcPeers.remove(peer)
+	peer.run()
+}
+
+// Shutdown Stop listening, wait for the requests in process until the ctx is done and then close the connections.
+func (engine *rpcSocketServerEngine) Shutdown(ctx context.Context) error {
+	if engine.listener != nil {
+		err := engine.listener.Close()
+		if err != nil {
+			logger.Warning("Close the listener of engine error: " + err.Error())
+		}
+		engine.listener = nil
+	}
+	err := engine.RpcServerEngineCore.Drain(ctx)
+	engine.rpcPeers.closeAll()
+	engine.RpcServerEngineCore.SetRouter(nil)
+	return err
+}
+
+// Stop the engine and free the router.
+func (engine *rpcSocketServerEngine) Stop() {
+	if engine.listener != nil {
+		err := engine.listener.Close()
+		if err != nil {
+			logger.Warning("Close the listener of engine error: " + err.Error())
+		}
+		engine.listener = nil
+	}
+	engine.rpcPeers.closeAll()
+	engine.RpcServerEngineCore.SetRouter(nil)
+}
+
+// newRpcSocketServerEngine Create a server engine which listens on the network address.
+func newRpcSocketServerEngine(name string, network string, address string, framing RpcFraming, options []RpcServerOption) *rpcSocketServerEngine {
+	engine := new(rpcSocketServerEngine)
+	engine.name = name
+	engine.network = network
+	engine.address = address
+	engine.framing = framing
+	engine.options = newRpcServerOptions(options)
+	if engine.options.address != "" {
+		engine.address = engine.options.address
+	}
+	engine.rpcPeers = newRpcPeers()
+	engine.RpcServerEngineCore = new(RpcServerEngineCore)
+	return engine
+}
+
+// NewRpcTcpServerEngine Create a new tcp server engine, the methods of requests are qualified as "Service.Method".
+// It listens on ":port" unless the options give another address or listener.
+func NewRpcTcpServerEngine(port int, framing RpcFraming, options ...RpcServerOption) RpcServerEngine {
+	return newRpcSocketServerEngine("RpcTcpServerEngine", "tcp", ":"+strconv.Itoa(port), framing, options)
+}
+
+// removeStaleSocket Remove the socket file left by a dead server, it fails if a server is still listening on it.
+func removeStaleSocket(socketPath string) error {
+	info, err := os.Lstat(socketPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return errors.New("The file " + socketPath + " exists and is not a socket.")
+	}
+	conn, err := net.DialTimeout("unix", socketPath, time.Second)
+	if err == nil {
+		_ = conn.Close()
+		return errors.New("The socket " + socketPath + " is in use by another server.")
+	}
+	logger.Info("Remove the stale socket " + socketPath)
+	return os.Remove(socketPath)
+}
+
+// NewRpcUnixServerEngine Create a new unix domain socket server engine, the socket file is created with the fileMode
+// if it is not 0, and the requests whose methods are not qualified as "Service.Method" go to the defaultService.
+func NewRpcUnixServerEngine(socketPath string, fileMode os.FileMode, framing RpcFraming, defaultService string, options ...RpcServerOption) RpcServerEngine {
+	engine := newRpcSocketServerEngine("RpcUnixServerEngine", "unix", socketPath, framing, options)
+	engine.fileMode = fileMode
+	engine.defaultService = defaultService
+	return engine
+}
+
+// A client engine which keeps one persistent connection, the requests are multiplexed by id.
+type rpcSocketClientEngine struct {
+	name     string
+	network  string
+	address  string
+	framing  RpcFraming
+	locker   *sync.Mutex
+	peer     *RpcPeer
+	dialing  *rpcPeerDial             //The dial in process, the other callers wait for it
+	closes   int                      //The count of Close, the connection dialed across Close is discarded
+	handlers *rpcNotificationHandlers //Handle the notifications pushed by the server
+}
+
+// GetName Get the engine name.
+func (engine *rpcSocketClientEngine) GetName() string {
+	return engine.name
+}
+
+// getPeer Get the connection, a new one is dialed if not exists or closed.
+func (engine *rpcSocketClientEngine) getPeer(ctx context.Context) (*RpcPeer, error) {
+	for {
+		engine.locker.Lock()
+		if engine.peer != nil && !engine.peer.isClosed() {
+			peer := engine.peer
+			engine.locker.Unlock()
+			return peer, nil
+		}
+		dial := engine.dialing
+		if dial == nil {
+			//Dial without the lock, so a slow server does not block the callers with their own deadlines and Close.
+			dial = &rpcPeerDial{done: make(chan struct{})}
+			engine.dialing = dial
+			closes := engine.closes
+			engine.locker.Unlock()
+			dialer := new(net.Dialer)
+			conn, err := dialer.DialContext(ctx, engine.network, engine.address)
+			engine.locker.Lock()
+			engine.dialing = nil
+			if err == nil && engine.closes != closes {
+				_ = conn.Close()
+				err = errors.New("the engine has been closed.")
+			}
+			if err == nil {
+				stream := newRpcStreamConn(conn, conn, conn, engine.framing, conn.RemoteAddr().String())
+				dial.peer = newRpcClientPeer(stream, nil, engine.GetName(), engine.handlers)
+				engine.peer = dial.peer
+			}
+			dial.err = err
+			close(dial.done)
+			engine.locker.Unlock()
+			return dial.peer, dial.err
+		}
+		engine.locker.Unlock()
+		select {
+		case <-dial.done:
+			if dial.err == nil {
+				return dial.peer, nil
+			}
+			if !errors.Is(dial.err, context.Canceled) && !errors.Is(dial.err, context.DeadlineExceeded) {
+				return nil, dial.err
+			}
+			//The dial was stopped by the context of another caller, try again with this one.
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// ProcessData Send the rpc request data to the server.
+func (engine *rpcSocketClientEngine) ProcessData(serviceName string, method string, params []any) string {
+	requestData, err := encodeRequestData(nextRequestId(), method, params)
+	if err == nil {
+		return engine.ProcessString(serviceName, string(requestData))
+	} else {
+		var sendErr any = errors.New("Send request error: " + err.Error())
+		panic(sendErr)
+	}
+}
+
+// ProcessString Send the rpc request string to the server.
+func (engine *rpcSocketClientEngine) ProcessString(serviceName string, requestStr string) string {
+	response, err := engine.ProcessStringContext(context.Background(), serviceName, requestStr)
+	if err == nil {
+		return response
+	} else {
+		var sendErr any = err
+		panic(sendErr)
+	}
+}
+
+// ProcessStringContext Send the rpc request string to the server, transport failures are returned as error.
+func (engine *rpcSocketClientEngine) ProcessStringContext(ctx context.Context, serviceName string, requestStr string) (string, error) {
+	requestStr, err := qualifyRequestString(serviceName, requestStr)
+	if err != nil {
+		return "", errors.New("Send request error: " + err.Error())
+	}
+	peer, err := engine.getPeer(ctx)
+	if err != nil {
+		return "", errors.New("Send request error: " + err.Error())
+	}
+	return peer.call(ctx, requestStr)
+}
+
+// Close the engine and the connection.
+func (engine *rpcSocketClientEngine) Close() {
+	engine.locker.Lock()
+	peer := engine.peer
+	engine.peer = nil
+	engine.closes++
+	engine.locker.Unlock()
+	if peer != nil {
+		peer.Close()
+	}
+}
+
+// newRpcSocketClientEngine Create a client engine which dials the network address.
+func newRpcSocketClientEngine(name string, network string, address string, framing RpcFraming) *rpcSocketClientEngine {
+	engine := new(rpcSocketClientEngine)
+	engine.name = name
+	engine.network = network
+	engine.address = address
+	engine.framing = framing
+	engine.locker = new(sync.Mutex)
+	engine.handlers = newRpcNotificationHandlers()
+	return engine
+}
+
+// OnNotification Register the handler of the notification method pushed by the server, see RpcPeer.OnNotification.
+func (engine *rpcSocketClientEngine) OnNotification(method string, handler any) {
+	engine.handlers.register(method, handler)
+}
+
+// Subscribe Call the subscription method of the service, see RpcPeer.Subscribe.
+func (engine *rpcSocketClientEngine) Subscribe(ctx context.Context, serviceName string, method string, params []any, handler any) (string, error) {
+	return subscribe(ctx, func(ctx context.Context, requestStr string) (string, error) {
+		return engine.ProcessStringContext(ctx, serviceName, requestStr)
+	}, method, params, handler)
+}
+
+// Unsubscribe Cancel the subscription made by Subscribe.
+func (engine *rpcSocketClientEngine) Unsubscribe(ctx context.Context, serviceName string, subscriptionId string) error {
+	engine.handlers.removeSubscription(subscriptionId)
+	return unsubscribeRemote(ctx, func(ctx context.Context, requestStr string) (string, error) {
+		return engine.ProcessStringContext(ctx, serviceName, requestStr)
+	}, UnsubscribeMethod, subscriptionId)
+}
+
+// Stream Call the method of the service which returns a channel, see RpcPeer.Stream.
+func (engine *rpcSocketClientEngine) Stream(ctx context.Context, serviceName string, method string, params []any, handler any) error {
+	return streamCall(ctx, func(ctx context.Context, requestStr string) (string, error) {
+		return engine.ProcessStringContext(ctx, serviceName, requestStr)
+	}, method, params, handler)
+}
+
+// NewRpcTcpClientEngine Create a new tcp client engine, the serverAddress looks like localhost:8080.
+func NewRpcTcpClientEngine(serverAddress string, framing RpcFraming) RpcClientEngine {
+	return newRpcSocketClientEngine("RpcTcpClientEngine", "tcp", serverAddress, framing)
+}
+
+// NewRpcUnixClientEngine Create a new unix domain socket client engine which dials the socketPath.
+func NewRpcUnixClientEngine(socketPath string, framing RpcFraming) RpcClientEngine {
+	return newRpcSocketClientEngine("RpcUnixClientEngine", "unix", socketPath, framing)
+}
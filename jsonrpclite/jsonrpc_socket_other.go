@@ -0,0 +1,27 @@
+//go:build !(aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris)
+
+package jsonrpclite
+
+import (
+	"net"
+	"os"
+)
+
+// listenUnixMode Listen on the unix socket and change the mode of its file, the platform has no umask so the mode is
+// applied after the bind.
+func listenUnixMode(socketPath string, mode os.FileMode) (net.Listener, error) {
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(socketPath, mode)
+	if err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// restoreNonblock The listeners can not be handed to another process on the platform, nothing to restore.
+func restoreNonblock(file *os.File) {
+}
@@ -0,0 +1,37 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+package jsonrpclite
+
+import (
+	"net"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// umaskLocker Serialize the changes of the process umask.
+var umaskLocker sync.Mutex
+
+// listenUnixMode Listen on the unix socket whose file gets the mode when it is bound, the umask is narrowed meanwhile
+// so the socket is never reachable with wider permissions. The files created by other goroutines during the bind get
+// the narrowed umask as well.
+func listenUnixMode(socketPath string, mode os.FileMode) (net.Listener, error) {
+	umaskLocker.Lock()
+	defer umaskLocker.Unlock()
+	oldMask := syscall.Umask(int(^mode.Perm() & os.ModePerm))
+	defer syscall.Umask(oldMask)
+	return net.Listen("unix", socketPath)
+}
+
+// restoreNonblock Put the file of a listener handed to another process back into the non-blocking mode, which os/exec
+// clears when it passes the file. The listener of this process shares the mode, its Accept could not be interrupted by
+// Close otherwise.
+func restoreNonblock(file *os.File) {
+	rawConn, err := file.SyscallConn()
+	if err != nil {
+		return
+	}
+	_ = rawConn.Control(func(fd uintptr) {
+		_ = syscall.SetNonblock(int(fd), true)
+	})
+}
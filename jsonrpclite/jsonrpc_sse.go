@@ -0,0 +1,437 @@
+package jsonrpclite
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sseBufferSize      = 1024             //The count of the latest events kept for the reconnected clients
+	sseClientQueueSize = 256              //The count of events queued for a client, the slow client is disconnected
+	sseKeepAlive       = 15 * time.Second //The interval of the comments which keep the proxies from closing the stream
+	sseMemberTimeout   = 10 * time.Minute //The time to keep the id and groups of a disconnected client for reconnecting
+)
+
+// EventsPeerHeader The header of the http requests which carries the peer id given by the events endpoint, the
+// service methods get the events client by EventsPeerFromContext.
+const EventsPeerHeader = "X-Events-Peer"
+
+// The target of a pushed event.
+type sseTargetKind uint8
+
+const (
+	sseTargetAll   sseTargetKind = iota //All clients
+	sseTargetGroup                      //The clients in the group
+	sseTargetPeer                       //The client with the peer id
+)
+
+// An event pushed to the server-sent events clients.
+type rpcSseEvent struct {
+	id     uint64
+	kind   sseTargetKind
+	target string //The group or the peer id
+	data   []byte //The JSON-RPC notification
+}
+
+// format Format the event in the text/event-stream format.
+func (event *rpcSseEvent) format() string {
+	return "id: " + strconv.FormatUint(event.id, 10) + "\ndata: " + string(event.data) + "\n\n"
+}
+
+// The id and groups given by the server to an events client, they are kept while the client reconnects.
+type rpcSseMember struct {
+	id      string
+	groups  map[string]bool
+	streams int       //The count of the connected streams
+	left    time.Time //The time when the last stream was disconnected
+}
+
+// A stream connected to the events endpoint.
+type rpcSseClient struct {
+	member  *rpcSseMember
+	events  chan *rpcSseEvent
+	dropped chan struct{} //Closed when the client is too slow or the engine stops
+	once    *sync.Once
+}
+
+// matches Check whether the event is sent to the client, the caller must hold the locker of the hub.
+func (client *rpcSseClient) matches(event *rpcSseEvent) bool {
+	switch event.kind {
+	case sseTargetGroup:
+		return client.member.groups[event.target]
+	case sseTargetPeer:
+		return client.member.id == event.target
+	default:
+		return true
+	}
+}
+
+// drop Disconnect the client.
+func (client *rpcSseClient) drop() {
+	client.once.Do(func() {
+		close(client.dropped)
+	})
+}
+
+// The hub of the server-sent events clients of a service, it keeps the latest events so that the client which
+// reconnects with Last-Event-ID gets the missed ones.
+type rpcSseHub struct {
+	locker  *sync.Mutex
+	lastId  uint64
+	buffer  []*rpcSseEvent //The latest events in order, bounded by sseBufferSize
+	clients map[*rpcSseClient]bool
+	members map[string]*rpcSseMember //The members by peer id
+}
+
+// publish Keep the event and send it to the matched clients, returns the count of the clients.
+func (hub *rpcSseHub) publish(kind sseTargetKind, target string, method string, params []any) (int, error) {
+	data, err := encodeRequestData(nil, method, params)
+	if err != nil {
+		return 0, errors.New("Encode notification error: " + err.Error())
+	}
+	hub.locker.Lock()
+	defer hub.locker.Unlock()
+	hub.lastId++
+	event := &rpcSseEvent{hub.lastId, kind, target, data}
+	if len(hub.buffer) >= sseBufferSize {
+		hub.buffer = append(hub.buffer[:0], hub.buffer[1:]...)
+	}
+	hub.buffer = append(hub.buffer, event)
+	count := 0
+	for client := range hub.clients {
+		if !client.matches(event) {
+			continue
+		}
+		select {
+		case client.events <- event:
+			count++
+		default:
+			//The client reconnects and gets the missed events from the buffer.
+			logger.Warning("The events client " + client.member.id + " is too slow, it will be disconnected.")
+			delete(hub.clients, client)
+			client.drop()
+		}
+	}
+	return count, nil
+}
+
+// connect Add the client as the member with the peer id, a new member is created if the id was not given by this
+// hub. Returns the buffered events after lastEventId which are written before the queued ones, so the replay is not
+// bounded by the queue. The lastEventId is 0 for a new client.
+func (hub *rpcSseHub) connect(client *rpcSseClient, peerId string, lastEventId uint64) []*rpcSseEvent {
+	hub.locker.Lock()
+	defer hub.locker.Unlock()
+	now := time.Now()
+	for id, member := range hub.members {
+		if member.streams == 0 && now.Sub(member.left) >= sseMemberTimeout {
+			delete(hub.members, id)
+		}
+	}
+	member := hub.members[peerId]
+	if member == nil {
+		member = &rpcSseMember{id: newSubscriptionId(), groups: make(map[string]bool)}
+		hub.members[member.id] = member
+	}
+	member.streams++
+	client.member = member
+	var missed []*rpcSseEvent
+	if lastEventId > 0 {
+		for _, event := range hub.buffer {
+			if event.id > lastEventId && client.matches(event) {
+				missed = append(missed, event)
+			}
+		}
+	}
+	hub.clients[client] = true
+	return missed
+}
+
+// disconnect Remove the client, its member is kept for sseMemberTimeout.
+func (hub *rpcSseHub) disconnect(client *rpcSseClient) {
+	hub.locker.Lock()
+	defer hub.locker.Unlock()
+	delete(hub.clients, client)
+	client.member.streams--
+	if client.member.streams == 0 {
+		client.member.left = time.Now()
+	}
+}
+
+// closeAll Disconnect all the clients.
+func (hub *rpcSseHub) closeAll() {
+	hub.locker.Lock()
+	defer hub.locker.Unlock()
+	for client := range hub.clients {
+		client.drop()
+	}
+	hub.clients = make(map[*rpcSseClient]bool)
+}
+
+// hasMember Check whether the peer id was given by this hub.
+func (hub *rpcSseHub) hasMember(peerId string) bool {
+	hub.locker.Lock()
+	defer hub.locker.Unlock()
+	return hub.members[peerId] != nil
+}
+
+// join Add the member into the group.
+func (hub *rpcSseHub) join(peerId string, group string) {
+	hub.locker.Lock()
+	defer hub.locker.Unlock()
+	member := hub.members[peerId]
+	if member != nil {
+		member.groups[group] = true
+	}
+}
+
+// leave Remove the member from the group.
+func (hub *rpcSseHub) leave(peerId string, group string) {
+	hub.locker.Lock()
+	defer hub.locker.Unlock()
+	member := hub.members[peerId]
+	if member != nil {
+		delete(member.groups, group)
+	}
+}
+
+// NotifyPeer Push the notification to the events client with the peer id, the event is kept for replay even if the
+// client is not connected now.
+func (hub *rpcSseHub) NotifyPeer(peerId string, method string, params []any) error {
+	count, err := hub.publish(sseTargetPeer, peerId, method, params)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New("The peer " + peerId + " is not connected.")
+	}
+	return nil
+}
+
+// NotifyGroup Push the notification to the events clients in the group.
+func (hub *rpcSseHub) NotifyGroup(group string, method string, params []any) (int, error) {
+	return hub.publish(sseTargetGroup, group, method, params)
+}
+
+// Broadcast Push the notification to all events clients.
+func (hub *rpcSseHub) Broadcast(method string, params []any) (int, error) {
+	return hub.publish(sseTargetAll, "", method, params)
+}
+
+// serveEvents Stream the pushed notifications to the client until it disconnects. The peer id is given by the server
+// in the first event named "peer", the client sends it back by ?peer= when it reconnects.
+func (hub *rpcSseHub) serveEvents(writer http.ResponseWriter, request *http.Request) {
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		http.Error(writer, "Streaming is not supported.", http.StatusInternalServerError)
+		return
+	}
+	query := request.URL.Query()
+	client := new(rpcSseClient)
+	client.events = make(chan *rpcSseEvent, sseClientQueueSize)
+	client.dropped = make(chan struct{})
+	client.once = new(sync.Once)
+	lastEventIdStr := request.Header.Get("Last-Event-ID")
+	if lastEventIdStr == "" {
+		//Some polyfills can not set the header.
+		lastEventIdStr = query.Get("lastEventId")
+	}
+	lastEventId, _ := strconv.ParseUint(lastEventIdStr, 10, 64)
+	missed := hub.connect(client, query.Get("peer"), lastEventId)
+	defer hub.disconnect(client)
+	peerId := client.member.id
+
+	writer.Header().Set("Server", "JsonRpcLite-Go")
+	writer.Header().Set("Access-Control-Allow-Origin", "*")
+	writer.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.Header().Set("X-Accel-Buffering", "no")
+	writer.WriteHeader(http.StatusOK)
+	var replay strings.Builder
+	replay.WriteString("event: peer\ndata: " + peerId + "\n\n")
+	for _, event := range missed {
+		replay.WriteString(event.format())
+	}
+	_, err := writer.Write([]byte(replay.String()))
+	if err != nil {
+		return
+	}
+	flusher.Flush()
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		var content string
+		select {
+		case event := <-client.events:
+			content = event.format()
+		case <-keepAlive.C:
+			content = ": ping\n\n"
+		case <-client.dropped:
+			return
+		case <-request.Context().Done():
+			return
+		}
+		_, err = writer.Write([]byte(content))
+		if err != nil {
+			logger.Debug("Write events to client " + peerId + " error: " + err.Error())
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// eventsServiceName Get the service of the events endpoint like /Service/events, empty if it is not.
+func eventsServiceName(path string) string {
+	path = strings.Trim(path, "/")
+	if !strings.HasSuffix(path, "/events") {
+		return ""
+	}
+	return strings.TrimSuffix(path, "/events")
+}
+
+// newRpcSseHub Create a hub without clients.
+func newRpcSseHub() *rpcSseHub {
+	hub := new(rpcSseHub)
+	hub.locker = new(sync.Mutex)
+	hub.clients = make(map[*rpcSseClient]bool)
+	hub.members = make(map[string]*rpcSseMember)
+	return hub
+}
+
+// RpcEventsPeer A client of the events endpoint of a service, the service methods get it by EventsPeerFromContext
+// when the http request carries the peer id in the EventsPeerHeader.
+type RpcEventsPeer struct {
+	hub *rpcSseHub
+	id  string
+}
+
+// Id Get the peer id which was given to the client by the events endpoint.
+func (peer *RpcEventsPeer) Id() string {
+	return peer.id
+}
+
+// JoinGroup Join the group of the events endpoint, then the notifications to the group are pushed to the client.
+func (peer *RpcEventsPeer) JoinGroup(group string) {
+	peer.hub.join(peer.id, group)
+}
+
+// LeaveGroup Leave the group of the events endpoint.
+func (peer *RpcEventsPeer) LeaveGroup(group string) {
+	peer.hub.leave(peer.id, group)
+}
+
+// withEventsPeer Attach the events client to the context.
+func withEventsPeer(ctx context.Context, peer *RpcEventsPeer) context.Context {
+	return context.WithValue(ctx, eventsPeerKey, peer)
+}
+
+// EventsPeerFromContext Get the events client which sent the http request with the EventsPeerHeader, nil if the
+// header is missing or the peer id was not given by the events endpoint of the service.
+func EventsPeerFromContext(ctx context.Context) *RpcEventsPeer {
+	peer, _ := ctx.Value(eventsPeerKey).(*RpcEventsPeer)
+	return peer
+}
+
+// The hubs of the events endpoints by service name, the events of a service do not reach the clients of others.
+type rpcSseHubs struct {
+	locker *sync.Mutex
+	hubs   map[string]*rpcSseHub
+}
+
+// get Get the hub of the service, it is created on the first use.
+func (hubs *rpcSseHubs) get(serviceName string) *rpcSseHub {
+	hubs.locker.Lock()
+	defer hubs.locker.Unlock()
+	hub := hubs.hubs[serviceName]
+	if hub == nil {
+		hub = newRpcSseHub()
+		hubs.hubs[serviceName] = hub
+	}
+	return hub
+}
+
+// snapshot Copy the hubs so that they can be used without holding the locker.
+func (hubs *rpcSseHubs) snapshot() []*rpcSseHub {
+	hubs.locker.Lock()
+	defer hubs.locker.Unlock()
+	result := make([]*rpcSseHub, 0, len(hubs.hubs))
+	for _, hub := range hubs.hubs {
+		result = append(result, hub)
+	}
+	return result
+}
+
+// serveEvents Stream the pushed notifications of the service to the client.
+func (hubs *rpcSseHubs) serveEvents(writer http.ResponseWriter, request *http.Request, serviceName string) {
+	hubs.get(serviceName).serveEvents(writer, request)
+}
+
+// withEventsPeer Attach the events client of the service to the context if the request carries its peer id.
+func (hubs *rpcSseHubs) withEventsPeer(ctx context.Context, serviceName string, request *http.Request) context.Context {
+	peerId := request.Header.Get(EventsPeerHeader)
+	if peerId == "" {
+		return ctx
+	}
+	hubs.locker.Lock()
+	hub := hubs.hubs[serviceName]
+	hubs.locker.Unlock()
+	if hub == nil || !hub.hasMember(peerId) {
+		return ctx
+	}
+	return withEventsPeer(ctx, &RpcEventsPeer{hub, peerId})
+}
+
+// closeAll Disconnect all the clients.
+func (hubs *rpcSseHubs) closeAll() {
+	for _, hub := range hubs.snapshot() {
+		hub.closeAll()
+	}
+}
+
+// NotifyPeer Push the notification to the events client with the peer id.
+func (hubs *rpcSseHubs) NotifyPeer(peerId string, method string, params []any) error {
+	for _, hub := range hubs.snapshot() {
+		if hub.hasMember(peerId) {
+			return hub.NotifyPeer(peerId, method, params)
+		}
+	}
+	return errors.New("The peer " + peerId + " is not connected.")
+}
+
+// NotifyGroup Push the notification to the events clients in the group of every service.
+func (hubs *rpcSseHubs) NotifyGroup(group string, method string, params []any) (int, error) {
+	count := 0
+	for _, hub := range hubs.snapshot() {
+		hubCount, err := hub.NotifyGroup(group, method, params)
+		if err != nil {
+			return count, err
+		}
+		count += hubCount
+	}
+	return count, nil
+}
+
+// Broadcast Push the notification to all events clients of every service.
+func (hubs *rpcSseHubs) Broadcast(method string, params []any) (int, error) {
+	count := 0
+	for _, hub := range hubs.snapshot() {
+		hubCount, err := hub.Broadcast(method, params)
+		if err != nil {
+			return count, err
+		}
+		count += hubCount
+	}
+	return count, nil
+}
+
+// newRpcSseHubs Create the hubs without services.
+func newRpcSseHubs() *rpcSseHubs {
+	hubs := new(rpcSseHubs)
+	hubs.locker = new(sync.Mutex)
+	hubs.hubs = make(map[string]*rpcSseHub)
+	return hubs
+}
@@ -0,0 +1,162 @@
+package jsonrpclite
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// RpcDoneEngine The engine which finishes by itself when the client has finished, like the stdio engine.
+type RpcDoneEngine interface {
+	Done() <-chan struct{}
+}
+
+// A server engine which reads the requests from stdin and writes the responses to stdout with Content-Length framing.
+type rpcStdioServerEngine struct {
+	serviceName string
+	reader      io.Reader
+	writer      io.Writer
+	locker      *sync.Mutex
+	stream      *rpcStreamConn
+	messages    chan []byte //The messages read from the reader, it is closed on EOF or a read error
+	readErr     error       //The error which ended the reading, set before messages is closed
+	peer        *RpcPeer
+	done        chan struct{}
+	*rpcPeers
+	*RpcServerEngineCore
+}
+
+// GetName Get the engine name.
+func (engine *rpcStdioServerEngine) GetName() string {
+	return "RpcStdioServerEngine"
+}
+
+// Start the engine and initialize the router, the requests are read in background.
+func (engine *rpcStdioServerEngine) Start(router *rpcRouter) error {
+	engine.locker.Lock()
+	defer engine.locker.Unlock()
+	if engine.peer != nil {
+		return errors.New("The stdio engine has already started.")
+	}
+	if engine.writer == os.Stdout {
+		//The console log would break the framing on stdout.
+		if consoleLogger, ok := logger.(*rpcConsoleLogger); ok {
+			consoleLogger.setWriter(os.Stderr)
+		}
+	}
+	engine.RpcServerEngineCore.SetRouter(router)
+	if engine.stream == nil {
+		//The reader is read by one goroutine for the engine, so no message is lost when the engine restarts.
+		engine.stream = newRpcStreamConn(engine.reader, engine.writer, nil, ContentLengthFraming, "stdio")
+		engine.messages = make(chan []byte)
+		go engine.read()
+	}
+	transportInfo := &RpcTransportInfo{Engine: engine.GetName(), RemoteAddr: "stdio"}
+	engine.peer = newRpcPeer(engine.stream, engine.RpcServerEngineCore, engine.serviceName, transportInfo)
+	engine.rpcPeers.add(engine.peer)
+	go engine.serve(engine.peer, engine.done)
+	return nil
+}
+
+// read Read the messages until EOF or a read error.
+func (engine *rpcStdioServerEngine) read() {
+	for {
+		message, err := engine.stream.readMessage()
+		if err != nil {
+			engine.readErr = err
+			close(engine.messages)
+			return
+		}
+		engine.messages <- message
+	}
+}
+
+// serve Dispatch the requests concurrently until EOF, the exit notification or the engine stops, then wait for the
+// requests in process.
+func (engine *rpcStdioServerEngine) serve(peer *RpcPeer, done chan struct{}) {
+	waitGroup := new(sync.WaitGroup)
+	defer func() {
+		waitGroup.Wait()
+		peer.Close()
+		engine.rpcPeers.remove(peer)
+		close(done)
+	}()
+	for {
+		var message []byte
+		var ok bool
+		select {
+		case message, ok = <-engine.messages:
+		case <-peer.Done():
+			return
+		}
+		if !ok {
+			if !errors.Is(engine.readErr, io.EOF) {
+				logger.Error("Read request from stdin error: " + engine.readErr.Error())
+			}
+			return
+		}
+		if !isRequestMessage(message) {
+			//The response of the call from the service to the client.
+			peer.deliver(message)
+			continue
+		}
+		method, id, err := peekRequest(string(message))
+		if err == nil && method == "exit" && id == nil {
+			logger.Info("Receive the exit notification, the stdio engine stops.")
+			return
+		}
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			peer.handle(string(message))
+		}()
+	}
+}
+
+// Done Get the channel which is closed when the engine stops reading on EOF, the exit notification or Stop.
+func (engine *rpcStdioServerEngine) Done() <-chan struct{} {
+	engine.locker.Lock()
+	defer engine.locker.Unlock()
+	return engine.done
+}
+
+// Shutdown Wait for the requests in process until the ctx is done, the requests which arrive meanwhile are rejected,
+// then close the connection.
+func (engine *rpcStdioServerEngine) Shutdown(ctx context.Context) error {
+	err := engine.RpcServerEngineCore.Drain(ctx)
+	engine.Stop()
+	return err
+}
+
+// Stop the engine and free the router, the requests in process are cancelled. The engine can be started again.
+func (engine *rpcStdioServerEngine) Stop() {
+	engine.locker.Lock()
+	defer engine.locker.Unlock()
+	if engine.peer != nil {
+		engine.peer.Close()
+		engine.peer = nil
+		engine.done = make(chan struct{})
+	}
+	engine.RpcServerEngineCore.SetRouter(nil)
+}
+
+// NewRpcStdioServerEngine Create a new stdio server engine, the requests whose methods are not qualified as
+// "Service.Method" go to the serviceName. Wait on the Done() of the RpcDoneEngine to know when the client has finished.
+func NewRpcStdioServerEngine(serviceName string) RpcServerEngine {
+	return newRpcStdioServerEngine(serviceName, os.Stdin, os.Stdout)
+}
+
+// newRpcStdioServerEngine Create a stdio server engine which works on the reader and writer.
+func newRpcStdioServerEngine(serviceName string, reader io.Reader, writer io.Writer) *rpcStdioServerEngine {
+	engine := new(rpcStdioServerEngine)
+	engine.serviceName = serviceName
+	engine.reader = reader
+	engine.writer = writer
+	engine.locker = new(sync.Mutex)
+	engine.done = make(chan struct{})
+	engine.rpcPeers = newRpcPeers()
+	engine.RpcServerEngineCore = new(RpcServerEngineCore)
+	return engine
+}
@@ -0,0 +1,191 @@
+package jsonrpclite
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+const (
+	StreamMethod      = "rpc.stream"           //The method of the notifications which carry the elements of the streamed results
+	StreamContentType = "application/x-ndjson" //The content type of the streamed results over http, one message in each line
+)
+
+// The params of the notification which carries an element of the streamed result of the request.
+type streamElement struct {
+	Id     any `json:"id"`
+	Result any `json:"result"`
+}
+
+// rpcStreamSink Send the elements of the streamed results before the final response.
+type rpcStreamSink interface {
+	// sendStreamElement Send an element of the result of the request with the id.
+	sendStreamElement(id any, element any) error
+}
+
+// encodeStreamElement Encode the notification which carries the element.
+func encodeStreamElement(id any, element any) ([]byte, error) {
+	return encodeRequestData(nil, StreamMethod, []any{streamElement{id, element}})
+}
+
+// withStreamSink Attach the sink of the streamed results to the context.
+func withStreamSink(ctx context.Context, sink rpcStreamSink) context.Context {
+	return context.WithValue(ctx, streamSinkKey, sink)
+}
+
+// withStreamHandler Attach the handler of the streamed elements of the call to the context.
+func withStreamHandler(ctx context.Context, handler *rpcMethod) context.Context {
+	return context.WithValue(ctx, streamHandlerKey, handler)
+}
+
+// drainStream Receive the elements from the channel returned by the method until it is closed, the elements are sent
+// by the sink of the transport and the count is returned, they are collected into a slice if there is no sink.
+func drainStream(ctx context.Context, id any, result any) (any, error) {
+	channel := reflect.ValueOf(result)
+	sink, _ := ctx.Value(streamSinkKey).(rpcStreamSink)
+	var elements reflect.Value
+	if sink == nil {
+		elements = reflect.MakeSlice(reflect.SliceOf(channel.Type().Elem()), 0, 16)
+	}
+	count := 0
+	if !channel.IsNil() {
+		cases := []reflect.SelectCase{
+			{Dir: reflect.SelectRecv, Chan: channel},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+		}
+		for {
+			chosen, element, ok := reflect.Select(cases)
+			if chosen == 1 {
+				return nil, errors.New("The stream is cancelled: " + ctx.Err().Error())
+			}
+			if !ok {
+				break
+			}
+			count++
+			if sink == nil {
+				elements = reflect.Append(elements, element)
+			} else if id != nil {
+				err := sink.sendStreamElement(id, element.Interface())
+				if err != nil {
+					return nil, errors.New("Send streamed element error: " + err.Error())
+				}
+			}
+		}
+	}
+	if sink == nil {
+		return elements.Interface(), nil
+	}
+	return count, nil
+}
+
+// streamCall Send the request through the persistent connection, the elements are passed to the handler in order
+// before the final response. The call is not limited by the default timeout since the stream can be long.
+func streamCall(ctx context.Context, send func(ctx context.Context, requestStr string) (string, error), method string, params []any, handler any) error {
+	eventHandler := newEventHandler(handler)
+	requestData, err := encodeRequestData(nextRequestId(), method, params)
+	if err != nil {
+		return errors.New("Encode request error: " + err.Error())
+	}
+	response, err := send(withStreamHandler(ctx, eventHandler), string(requestData))
+	if err != nil {
+		return err
+	}
+	return decodeResponseString(response, nil)
+}
+
+// collectStream Call the method whose result is collected into an array by the server, then pass the elements to the
+// handler, it is used when the transport can not stream.
+func collectStream(ctx context.Context, client *rpcClient, serviceName string, method string, params []any, handler any) error {
+	eventHandler := newEventHandler(handler)
+	var elements []json.RawMessage
+	err := client.Call(ctx, serviceName, method, params, &elements)
+	if err != nil {
+		return err
+	}
+	for _, element := range elements {
+		err = callEventHandler(ctx, eventHandler, element)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// acceptsStream Check whether the http client accepts the streamed results.
+func acceptsStream(request *http.Request) bool {
+	return strings.Contains(request.Header.Get("Accept"), StreamContentType)
+}
+
+// A sink which writes the messages as lines of the chunked http response.
+type rpcNdjsonWriter struct {
+	writer  http.ResponseWriter
+	locker  *sync.Mutex
+	started bool
+}
+
+// writeLine Write the message as a line and flush it to the client, the header is written before the first line.
+func (ndjson *rpcNdjsonWriter) writeLine(message []byte) error {
+	ndjson.locker.Lock()
+	defer ndjson.locker.Unlock()
+	if !ndjson.started {
+		ndjson.started = true
+		ndjson.writer.Header().Set("Server", "JsonRpcLite-Go")
+		ndjson.writer.Header().Set("Access-Control-Allow-Origin", "*")
+		ndjson.writer.Header().Set("Content-Type", StreamContentType+"; charset=utf-8")
+		ndjson.writer.WriteHeader(http.StatusOK)
+	}
+	_, err := ndjson.writer.Write(append(message, '\n'))
+	if err != nil {
+		return err
+	}
+	if flusher, ok := ndjson.writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// sendStreamElement Write the element as a line.
+func (ndjson *rpcNdjsonWriter) sendStreamElement(id any, element any) error {
+	message, err := encodeStreamElement(id, element)
+	if err != nil {
+		return err
+	}
+	return ndjson.writeLine(message)
+}
+
+// newRpcNdjsonWriter Create a sink on the http response.
+func newRpcNdjsonWriter(writer http.ResponseWriter) *rpcNdjsonWriter {
+	ndjson := new(rpcNdjsonWriter)
+	ndjson.writer = writer
+	ndjson.locker = new(sync.Mutex)
+	return ndjson
+}
+
+// readNdjsonStream Read the lines of the streamed http response, the elements are passed to the handler and the
+// final response is returned.
+func readNdjsonStream(ctx context.Context, reader *bufio.Reader, key string, handler *rpcMethod) (string, error) {
+	handlers := newRpcNotificationHandlers()
+	handlers.addStream(key, handler)
+	var response string
+	for {
+		line, err := reader.ReadBytes('\n')
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			if !handlers.handleStreamElement(ctx, line) {
+				response = string(line)
+			}
+		}
+		if err != nil {
+			if response == "" {
+				return "", errors.New("Read response error: " + err.Error())
+			}
+			return response, nil
+		}
+	}
+}
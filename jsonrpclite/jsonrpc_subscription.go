@@ -0,0 +1,261 @@
+package jsonrpclite
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+)
+
+const (
+	SubscriptionMethod = "rpc.subscription" //The method of the notifications which carry the events of subscriptions
+	UnsubscribeMethod  = "rpc.unsubscribe"  //The built-in method of every service to cancel a subscription
+)
+
+// The params of the notification which carries an event of the subscription.
+type subscriptionEvent struct {
+	Subscription string `json:"subscription"`
+	Result       any    `json:"result"`
+}
+
+// RpcSubscription The subscription made by a client on a persistent connection, a service method becomes a
+// subscription producer by taking it as the first param after the optional context.Context. The client gets the
+// id as the result and receives the events until it unsubscribes or disconnects.
+type RpcSubscription struct {
+	id        string
+	method    string
+	peer      *RpcPeer
+	ctx       context.Context //Cancelled when the subscription ends
+	cancel    context.CancelFunc
+	ready     chan struct{} //Closed when the response with the id has been sent
+	readyOnce *sync.Once
+}
+
+// Id Get the id of the subscription which is returned to the client.
+func (subscription *RpcSubscription) Id() string {
+	return subscription.id
+}
+
+// Method Get the method which made the subscription.
+func (subscription *RpcSubscription) Method() string {
+	return subscription.method
+}
+
+// Context Get the context which is cancelled when the subscription ends, it carries the peer of the client.
+func (subscription *RpcSubscription) Context() context.Context {
+	return subscription.ctx
+}
+
+// Done Get the channel which is closed when the client unsubscribes or disconnects, the producer should stop then.
+func (subscription *RpcSubscription) Done() <-chan struct{} {
+	return subscription.ctx.Done()
+}
+
+// Notify Send an event to the client, it waits until the client has got the subscription id.
+func (subscription *RpcSubscription) Notify(result any) error {
+	select {
+	case <-subscription.ready:
+	case <-subscription.ctx.Done():
+	}
+	if subscription.ctx.Err() != nil {
+		return errors.New("The subscription " + subscription.id + " has ended.")
+	}
+	requestData, err := encodeRequestData(nil, SubscriptionMethod, []any{subscriptionEvent{subscription.id, result}})
+	if err != nil {
+		return errors.New("Encode notification error: " + err.Error())
+	}
+	return subscription.peer.writeNotification(requestData)
+}
+
+// Close End the subscription from the server side.
+func (subscription *RpcSubscription) Close() {
+	subscription.peer.subscriptions.remove(subscription.id)
+	subscription.cancel()
+}
+
+// activate Allow the events to be sent.
+func (subscription *RpcSubscription) activate() {
+	subscription.readyOnce.Do(func() {
+		close(subscription.ready)
+	})
+}
+
+// newSubscriptionId Generate a random id like 0x3f9a...
+func newSubscriptionId() string {
+	data := make([]byte, 16)
+	_, err := rand.Read(data)
+	if err != nil {
+		var idErr any = errors.New("Generate subscription id error: " + err.Error())
+		panic(idErr)
+	}
+	return "0x" + hex.EncodeToString(data)
+}
+
+// The subscriptions made on a peer, they end when the peer is closed.
+type rpcSubscriptions struct {
+	locker *sync.Mutex
+	items  map[string]*RpcSubscription
+	closed bool
+}
+
+// add Track the subscription, returns false if the peer has been closed.
+func (subscriptions *rpcSubscriptions) add(subscription *RpcSubscription) bool {
+	subscriptions.locker.Lock()
+	defer subscriptions.locker.Unlock()
+	if subscriptions.closed {
+		return false
+	}
+	subscriptions.items[subscription.id] = subscription
+	return true
+}
+
+// remove Stop tracking the subscription, returns nil if it does not exist.
+func (subscriptions *rpcSubscriptions) remove(id string) *RpcSubscription {
+	subscriptions.locker.Lock()
+	defer subscriptions.locker.Unlock()
+	subscription := subscriptions.items[id]
+	delete(subscriptions.items, id)
+	return subscription
+}
+
+// closeAll End all the subscriptions, the later ones are rejected.
+func (subscriptions *rpcSubscriptions) closeAll() {
+	subscriptions.locker.Lock()
+	items := subscriptions.items
+	subscriptions.items = make(map[string]*RpcSubscription)
+	subscriptions.closed = true
+	subscriptions.locker.Unlock()
+	for _, subscription := range items {
+		subscription.cancel()
+	}
+}
+
+// newRpcSubscriptions Create an empty subscription set.
+func newRpcSubscriptions() *rpcSubscriptions {
+	subscriptions := new(rpcSubscriptions)
+	subscriptions.locker = new(sync.Mutex)
+	subscriptions.items = make(map[string]*RpcSubscription)
+	return subscriptions
+}
+
+// The subscriptions made while handling a message, their events are held until the response has been sent.
+type rpcSubscriptionCollector struct {
+	locker *sync.Mutex
+	items  []*RpcSubscription
+}
+
+// add Hold the events of the subscription.
+func (collector *rpcSubscriptionCollector) add(subscription *RpcSubscription) {
+	collector.locker.Lock()
+	defer collector.locker.Unlock()
+	collector.items = append(collector.items, subscription)
+}
+
+// activateAll Release the events of the collected subscriptions.
+func (collector *rpcSubscriptionCollector) activateAll() {
+	collector.locker.Lock()
+	defer collector.locker.Unlock()
+	for _, subscription := range collector.items {
+		subscription.activate()
+	}
+	collector.items = nil
+}
+
+// withSubscriptionCollector Attach the collector of the subscriptions made while handling the message to the context.
+func withSubscriptionCollector(ctx context.Context) (context.Context, *rpcSubscriptionCollector) {
+	collector := &rpcSubscriptionCollector{locker: new(sync.Mutex)}
+	return context.WithValue(ctx, subscriptionCollectorKey, collector), collector
+}
+
+// startSubscription Create the subscription on the peer which received the request.
+func startSubscription(ctx context.Context, method string) (*RpcSubscription, error) {
+	peer := PeerFromContext(ctx)
+	if peer == nil {
+		return nil, NewRpcError(MethodNotFoundCode, "Subscriptions are not available on this transport.", nil)
+	}
+	subscription := new(RpcSubscription)
+	subscription.id = newSubscriptionId()
+	subscription.method = method
+	subscription.peer = peer
+	subscription.ctx, subscription.cancel = context.WithCancel(peer.ctx)
+	subscription.ready = make(chan struct{})
+	subscription.readyOnce = new(sync.Once)
+	if !peer.subscriptions.add(subscription) {
+		subscription.cancel()
+		return nil, errors.New("The connection is closed.")
+	}
+	collector, ok := ctx.Value(subscriptionCollectorKey).(*rpcSubscriptionCollector)
+	if ok {
+		collector.add(subscription)
+	} else {
+		subscription.activate()
+	}
+	return subscription, nil
+}
+
+// unsubscribe The built-in method to cancel the subscription made on the same connection.
+func unsubscribe(ctx context.Context, subscriptionId string) bool {
+	peer := PeerFromContext(ctx)
+	if peer == nil {
+		return false
+	}
+	subscription := peer.subscriptions.remove(subscriptionId)
+	if subscription == nil {
+		return false
+	}
+	subscription.cancel()
+	return true
+}
+
+// withResponseHook Attach the hook which is called with the response before the next message is read.
+func withResponseHook(ctx context.Context, hook func(peer *RpcPeer, response string)) context.Context {
+	return context.WithValue(ctx, responseHookKey, hook)
+}
+
+// subscribe Send the subscription request, the handler is registered on the peer before its events are handled.
+func subscribe(ctx context.Context, send func(ctx context.Context, requestStr string) (string, error), method string, params []any, handler any) (string, error) {
+	//Validate the handler before the subscription is made.
+	newEventHandler(handler)
+	requestData, err := encodeRequestData(nextRequestId(), method, params)
+	if err != nil {
+		return "", errors.New("Encode request error: " + err.Error())
+	}
+	hook := func(peer *RpcPeer, response string) {
+		var id string
+		if decodeResponseString(response, &id) == nil {
+			peer.addSubscribed(id, newEventHandler(handler))
+		}
+	}
+	response, err := send(withResponseHook(ctx, hook), string(requestData))
+	if err != nil {
+		return "", err
+	}
+	var id string
+	err = decodeResponseString(response, &id)
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// unsubscribeRemote Send the request to cancel the subscription.
+func unsubscribeRemote(ctx context.Context, send func(ctx context.Context, requestStr string) (string, error), method string, subscriptionId string) error {
+	requestData, err := encodeRequestData(nextRequestId(), method, []any{subscriptionId})
+	if err != nil {
+		return errors.New("Encode request error: " + err.Error())
+	}
+	response, err := send(ctx, string(requestData))
+	if err != nil {
+		return err
+	}
+	var ok bool
+	err = decodeResponseString(response, &ok)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("The subscription " + subscriptionId + " does not exist.")
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package jsonrpclite
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultTlsReloadInterval The interval of checking the certificate files for change.
+const defaultTlsReloadInterval = 10 * time.Second
+
+// RpcTlsConfig The TLS configuration of the https engines, the files are PEM encoded and reloaded when they change.
+type RpcTlsConfig struct {
+	CertFile          string        //The certificate chain of this side, optional for the client without mutual TLS
+	KeyFile           string        //The private key of the certificate
+	CAFile            string        //The CAs which verify the other side, empty uses the system pool on the client
+	RequireClientCert bool          //The server requires and verifies the client certificate by CAFile (mutual TLS)
+	MinVersion        uint16        //The min TLS version like tls.VersionTLS13, 0 uses TLS 1.2
+	ServerName        string        //The name which verifies the server certificate, empty uses the host of the url
+	ReloadInterval    time.Duration //The interval of checking the files for change, 0 uses 10 seconds, negative disables it
+}
+
+// Keep the certificates and CAs loaded from the files, they are reloaded on handshakes after the files change.
+type rpcTlsReloader struct {
+	config      RpcTlsConfig
+	locker      *sync.Mutex
+	loaded      bool
+	checked     time.Time            //The last time of checking the files
+	modTimes    map[string]time.Time //The modification times of the loaded files
+	certificate *tls.Certificate
+	pool        *x509.CertPool
+}
+
+// files Get the configured files.
+func (reloader *rpcTlsReloader) files() []string {
+	files := make([]string, 0, 3)
+	for _, file := range []string{reloader.config.CertFile, reloader.config.KeyFile, reloader.config.CAFile} {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// load Read the files, the caller must hold the locker.
+func (reloader *rpcTlsReloader) load() error {
+	modTimes := make(map[string]time.Time)
+	for _, file := range reloader.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes[file] = info.ModTime()
+	}
+	var certificate *tls.Certificate
+	if reloader.config.CertFile != "" || reloader.config.KeyFile != "" {
+		pair, err := tls.LoadX509KeyPair(reloader.config.CertFile, reloader.config.KeyFile)
+		if err != nil {
+			return err
+		}
+		certificate = &pair
+	}
+	var pool *x509.CertPool
+	if reloader.config.CAFile != "" {
+		content, err := os.ReadFile(reloader.config.CAFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(content) {
+			return errors.New("No certificate is found in " + reloader.config.CAFile)
+		}
+	}
+	reloader.modTimes = modTimes
+	reloader.certificate = certificate
+	reloader.pool = pool
+	reloader.loaded = true
+	return nil
+}
+
+// changed Check whether any file has been modified since it was loaded, the caller must hold the locker.
+func (reloader *rpcTlsReloader) changed() bool {
+	for _, file := range reloader.files() {
+		info, err := os.Stat(file)
+		if err != nil || !info.ModTime().Equal(reloader.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+// current Get the loaded certificate and CAs, the files are loaded first or reloaded if they have changed.
+// The old ones are kept if the reload fails, so a half written file does not break the connections.
+func (reloader *rpcTlsReloader) current() (*tls.Certificate, *x509.CertPool, error) {
+	reloader.locker.Lock()
+	defer reloader.locker.Unlock()
+	if !reloader.loaded {
+		err := reloader.load()
+		if err != nil {
+			return nil, nil, errors.New("Load TLS files error: " + err.Error())
+		}
+		reloader.checked = time.Now()
+	} else if reloader.config.ReloadInterval >= 0 {
+		interval := reloader.config.ReloadInterval
+		if interval == 0 {
+			interval = defaultTlsReloadInterval
+		}
+		if time.Since(reloader.checked) >= interval {
+			reloader.checked = time.Now()
+			if reloader.changed() {
+				err := reloader.load()
+				if err != nil {
+					logger.Warning("Reload TLS files error, the old ones are kept: " + err.Error())
+				} else {
+					logger.Info("The TLS files have been reloaded.")
+				}
+			}
+		}
+	}
+	return reloader.certificate, reloader.pool, nil
+}
+
+// minVersion Get the min TLS version.
+func (reloader *rpcTlsReloader) minVersion() uint16 {
+	if reloader.config.MinVersion == 0 {
+		return tls.VersionTLS12
+	}
+	return reloader.config.MinVersion
+}
+
+// serverConfig Get the config of the server handshake.
+func (reloader *rpcTlsReloader) serverConfig(*tls.ClientHelloInfo) (*tls.Config, error) {
+	certificate, pool, err := reloader.current()
+	if err != nil {
+		return nil, err
+	}
+	if certificate == nil {
+		return nil, errors.New("The certificate of the server is not configured.")
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{*certificate},
+		MinVersion:   reloader.minVersion(),
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if reloader.config.RequireClientCert {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.ClientCAs = pool
+	} else if pool != nil {
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		config.ClientCAs = pool
+	}
+	return config, nil
+}
+
+// getCertificate Get the certificate of the server handshake.
+func (reloader *rpcTlsReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certificate, _, err := reloader.current()
+	if err == nil && certificate == nil {
+		err = errors.New("The certificate of the server is not configured.")
+	}
+	return certificate, err
+}
+
+// dialTLS Dial the server with the current certificate and CAs.
+func (reloader *rpcTlsReloader) dialTLS(ctx context.Context, network string, address string) (net.Conn, error) {
+	certificate, pool, err := reloader.current()
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		RootCAs:    pool,
+		MinVersion: reloader.minVersion(),
+		ServerName: reloader.config.ServerName,
+	}
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			host = address
+		}
+		config.ServerName = host
+	}
+	if certificate != nil {
+		config.Certificates = []tls.Certificate{*certificate}
+	}
+	dialer := &tls.Dialer{Config: config}
+	return dialer.DialContext(ctx, network, address)
+}
+
+// newRpcTlsReloader Create a reloader, the files are loaded on the first use.
+func newRpcTlsReloader(config RpcTlsConfig) *rpcTlsReloader {
+	reloader := new(rpcTlsReloader)
+	reloader.config = config
+	reloader.locker = new(sync.Mutex)
+	return reloader
+}
+
+// httpTransportInfo Get the transport metadata of the http request, the identity is set if the client certificate
+// has been verified.
+func httpTransportInfo(engineName string, request *http.Request) *RpcTransportInfo {
+	info := &RpcTransportInfo{Engine: engineName, RemoteAddr: request.RemoteAddr, Header: request.Header}
+	if request.TLS != nil && len(request.TLS.VerifiedChains) > 0 && len(request.TLS.VerifiedChains[0]) > 0 {
+		info.PeerCertificate = request.TLS.VerifiedChains[0][0]
+		info.Identity = info.PeerCertificate.Subject.String()
+	}
+	return info
+}
+
+// NewRpcHttpsServerEngine Create a http server engine which serves TLS, the client certificate is required when
+// RequireClientCert is set, then its subject is the caller identity got by IdentityFromContext.
+func NewRpcHttpsServerEngine(port int, config RpcTlsConfig, options ...RpcServerOption) RpcServerEngine {
+	engine := NewRpcHttpServerEngine(port, options...).(*rpcHttpServerEngine)
+	engine.tls = newRpcTlsReloader(config)
+	return engine
+}
+
+// NewRpcHttpsClientEngine Create a http client engine which talks TLS, the serverHost looks like https://localhost:8443.
+// The client certificate is sent if CertFile and KeyFile are configured.
+func NewRpcHttpsClientEngine(serverHost string, config RpcTlsConfig) RpcClientEngine {
+	engine := NewRpcHttpClientEngine(serverHost).(*rpcHttpClientEngine)
+	reloader := newRpcTlsReloader(config)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialTLSContext = reloader.dialTLS
+	engine.client.Transport = transport
+	return engine
+}
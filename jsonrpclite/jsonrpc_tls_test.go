@@ -0,0 +1,228 @@
+package jsonrpclite
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The service which tells the caller its identity.
+type tlsTestService struct{}
+
+func (service *tlsTestService) Whoami(ctx context.Context) string {
+	return IdentityFromContext(ctx)
+}
+
+// A certificate with its key, signed by the test CA.
+type tlsTestCert struct {
+	certificate *x509.Certificate
+	certPem     []byte
+	keyPem      []byte
+	key         *ecdsa.PrivateKey
+}
+
+// newTlsTestCert Create a certificate, it is self-signed when parent is nil.
+func newTlsTestCert(t *testing.T, parent *tlsTestCert, serial int64, commonName string, isServer bool) *tlsTestCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"JsonRpcLite"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else if isServer {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+		template.DNSNames = []string{"localhost"}
+	} else {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.certificate, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tlsTestCert{
+		certificate: certificate,
+		certPem:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPem:      pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+		key:         key,
+	}
+}
+
+// write Write the certificate and key files, they are stamped with modTime so the change is always seen.
+func (cert *tlsTestCert) write(t *testing.T, certFile string, keyFile string, modTime time.Time) {
+	t.Helper()
+	for file, content := range map[string][]byte{certFile: cert.certPem, keyFile: cert.keyPem} {
+		err := os.WriteFile(file, content, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.Chtimes(file, modTime, modTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// The files of the test CA, server and client certificates.
+type tlsTestFiles struct {
+	ca         *tlsTestCert
+	caFile     string
+	serverCert string
+	serverKey  string
+	clientCert string
+	clientKey  string
+}
+
+// newTlsTestFiles Create the CA, the server certificate for 127.0.0.1 and a client certificate in a temp dir.
+func newTlsTestFiles(t *testing.T) *tlsTestFiles {
+	t.Helper()
+	dir := t.TempDir()
+	files := &tlsTestFiles{
+		ca:         newTlsTestCert(t, nil, 1, "Test CA", false),
+		caFile:     filepath.Join(dir, "ca.crt"),
+		serverCert: filepath.Join(dir, "server.crt"),
+		serverKey:  filepath.Join(dir, "server.key"),
+		clientCert: filepath.Join(dir, "client.crt"),
+		clientKey:  filepath.Join(dir, "client.key"),
+	}
+	err := os.WriteFile(files.caFile, files.ca.certPem, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newTlsTestCert(t, files.ca, 2, "localhost", true).write(t, files.serverCert, files.serverKey, time.Now())
+	newTlsTestCert(t, files.ca, 3, "test-client", false).write(t, files.clientCert, files.clientKey, time.Now())
+	return files
+}
+
+// startTlsTestServer Start a https server on an ephemeral port, returns its url.
+func startTlsTestServer(t *testing.T, config RpcTlsConfig) string {
+	t.Helper()
+	router := NewRpcRouter()
+	router.RegisterService("Tls", new(tlsTestService))
+	server := NewRpcServer(NewRpcHttpsServerEngine(0, config, WithAddress("127.0.0.1:0")))
+	err := server.Start(router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Stop)
+	return "https://" + server.Addr().String()
+}
+
+func TestHttpsRoundTrip(t *testing.T) {
+	files := newTlsTestFiles(t)
+	serverHost := startTlsTestServer(t, RpcTlsConfig{CertFile: files.serverCert, KeyFile: files.serverKey})
+	client := NewRpcClient(NewRpcHttpsClientEngine(serverHost, RpcTlsConfig{CAFile: files.caFile}))
+	defer client.Close()
+	var identity string
+	err := client.Call(context.Background(), "Tls", "Whoami", nil, &identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity != "" {
+		t.Fatalf("The identity without client certificate should be empty, got %q", identity)
+	}
+}
+
+func TestHttpsRejectsClientWithoutCertificate(t *testing.T) {
+	files := newTlsTestFiles(t)
+	serverHost := startTlsTestServer(t, RpcTlsConfig{
+		CertFile:          files.serverCert,
+		KeyFile:           files.serverKey,
+		CAFile:            files.caFile,
+		RequireClientCert: true,
+	})
+	client := NewRpcClient(NewRpcHttpsClientEngine(serverHost, RpcTlsConfig{CAFile: files.caFile}))
+	defer client.Close()
+	var identity string
+	err := client.Call(context.Background(), "Tls", "Whoami", nil, &identity)
+	if err == nil {
+		t.Fatal("The client without certificate should be rejected.")
+	}
+}
+
+func TestHttpsIdentityFromClientCertificate(t *testing.T) {
+	files := newTlsTestFiles(t)
+	serverHost := startTlsTestServer(t, RpcTlsConfig{
+		CertFile:          files.serverCert,
+		KeyFile:           files.serverKey,
+		CAFile:            files.caFile,
+		RequireClientCert: true,
+	})
+	client := NewRpcClient(NewRpcHttpsClientEngine(serverHost, RpcTlsConfig{
+		CertFile: files.clientCert,
+		KeyFile:  files.clientKey,
+		CAFile:   files.caFile,
+	}))
+	defer client.Close()
+	var identity string
+	err := client.Call(context.Background(), "Tls", "Whoami", nil, &identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := pkix.Name{CommonName: "test-client", Organization: []string{"JsonRpcLite"}}.String()
+	if identity != expected {
+		t.Fatalf("The identity should be %q, got %q", expected, identity)
+	}
+}
+
+func TestHttpsReloadsCertificate(t *testing.T) {
+	files := newTlsTestFiles(t)
+	serverHost := startTlsTestServer(t, RpcTlsConfig{
+		CertFile:       files.serverCert,
+		KeyFile:        files.serverKey,
+		ReloadInterval: 50 * time.Millisecond,
+	})
+	address := serverHost[len("https://"):]
+	pool := x509.NewCertPool()
+	pool.AddCert(files.ca.certificate)
+	serverSerial := func() int64 {
+		conn, err := tls.Dial("tcp", address, &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+	if serial := serverSerial(); serial != 2 {
+		t.Fatalf("The serial of the server certificate should be 2, got %d", serial)
+	}
+	newTlsTestCert(t, files.ca, 4, "localhost", true).write(t, files.serverCert, files.serverKey,
+		time.Now().Add(time.Minute))
+	time.Sleep(100 * time.Millisecond)
+	if serial := serverSerial(); serial != 4 {
+		t.Fatalf("The serial of the reloaded server certificate should be 4, got %d", serial)
+	}
+}
@@ -1,158 +1,367 @@
 package jsonrpclite
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
+	"strings"
+	"sync/atomic"
 )
 
+var requestIdSeed int64
+
+// nextRequestId Generate the id for the request sent by this process, the ids are unique across engines.
+func nextRequestId() int64 {
+	return atomic.AddInt64(&requestIdSeed, 1)
+}
+
 type requestData struct {
 	JsonRpc string          `json:"jsonrpc"`
-	Id      any             `json:"id"`
+	Id      any             `json:"id,omitempty"`
 	Method  string          `json:"method"`
-	Params  json.RawMessage `json:"params"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type responseData struct {
+	JsonRpc string          `json:"jsonrpc"`
+	Id      any             `json:"id"`
+	Result  json.RawMessage `json:"result"`
+	Error   *RpcError       `json:"error"`
+}
+
+// encodeRequestData Encode the request, a single object or array param is sent as it is, others are sent as an array.
+func encodeRequestData(id any, method string, params []any) ([]byte, error) {
+	data := requestData{"2.0", id, method, nil}
+	if len(params) > 0 {
+		if len(params) == 1 {
+			paramData, err := json.Marshal(params[0])
+			if err != nil {
+				return nil, err
+			}
+			if len(paramData) > 0 && paramData[0] != '{' && paramData[0] != '[' {
+				//The params must be structured, so the scalar is sent in an array.
+				paramData = append(append([]byte{'['}, paramData...), ']')
+			}
+			data.Params = paramData
+		} else {
+			paramsData, err := json.Marshal(params)
+			if err != nil {
+				return nil, err
+			}
+			data.Params = paramsData
+		}
+	}
+	return json.Marshal(data)
+}
+
+// rejectRequestString Answer the requests with method not found errors, for the end which serves no services.
+func rejectRequestString(requestStr string) string {
+	requests, isBatch := decodeRequestString(newRpcService("", nil), requestStr)
+	responses := make([]rpcResponse, 0, len(requests))
+	for _, request := range requests {
+		if !request.isNotification() {
+			responses = append(responses, rpcResponse{request.id, true, request.err})
+		}
+	}
+	if len(responses) == 0 {
+		return ""
+	}
+	return string(encodeResponses(responses, isBatch))
+}
+
+// qualifyRequestString Qualify the methods of the request or the requests in a batch as "Service.Method".
+func qualifyRequestString(serviceName string, requestStr string) (string, error) {
+	if serviceName == "" {
+		return requestStr, nil
+	}
+	qualify := func(data map[string]json.RawMessage) error {
+		var method string
+		err := json.Unmarshal(data["method"], &method)
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(method, serviceName+".") {
+			data["method"], err = json.Marshal(serviceName + "." + method)
+		}
+		return err
+	}
+	requestStr = strings.TrimSpace(requestStr)
+	var result []byte
+	if len(requestStr) > 0 && requestStr[0] == '[' {
+		var requestsData []map[string]json.RawMessage
+		err := json.Unmarshal([]byte(requestStr), &requestsData)
+		if err != nil {
+			return "", err
+		}
+		for _, data := range requestsData {
+			err = qualify(data)
+			if err != nil {
+				return "", err
+			}
+		}
+		result, err = json.Marshal(requestsData)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		var data map[string]json.RawMessage
+		err := json.Unmarshal([]byte(requestStr), &data)
+		if err != nil {
+			return "", err
+		}
+		err = qualify(data)
+		if err != nil {
+			return "", err
+		}
+		result, err = json.Marshal(data)
+		if err != nil {
+			return "", err
+		}
+	}
+	return string(result), nil
+}
+
+// decodeResponseString Decode the response of a single request, the result is unmarshalled into result if it is not nil.
+func decodeResponseString(responseStr string, result any) error {
+	if strings.TrimSpace(responseStr) == "" {
+		return errors.New("Decode response error: the response is empty.")
+	}
+	var response responseData
+	err := json.Unmarshal([]byte(responseStr), &response)
+	if err != nil {
+		return errors.New("Decode response error: " + err.Error())
+	}
+	if response.Error != nil {
+		return response.Error
+	}
+	if result != nil && len(response.Result) > 0 {
+		err = json.Unmarshal(response.Result, result)
+		if err != nil {
+			return errors.New("Decode result error: " + err.Error())
+		}
+	}
+	return nil
 }
 
-func decodeRequest(service *rpcService, data requestData) rpcRequest {
+// decodeRequest Decode the request data into rpcRequest, the error is stored in the request if decoding failed.
+func decodeRequest(service *rpcService, data requestData) (request rpcRequest) {
+	//The method can be qualified as "Service.Method" when the transport carries many services.
+	methodName := strings.TrimPrefix(data.Method, service.name+".")
+	request = rpcRequest{id: data.Id, method: methodName, service: service}
 	defer func() {
 		var p = any(recover())
 		if p != nil {
-			responseErr, ok := p.(*RpcResponseError)
-			if ok {
-				panic(any(responseErr))
-			} else {
+			rpcErr, ok := p.(*RpcError)
+			if !ok {
 				errStr := fmt.Sprintln("The JSON sent is not a valid Request object.") + fmt.Sprintf("%v", p)
-				err := newRpcError(-32600, errStr)
-				response := rpcResponse{-1, true, err}
-				var responseErr any = newRpcResponseError(response)
-				panic(responseErr)
+				rpcErr = NewRpcError(InvalidRequestCode, errStr, nil)
+				request.invalid = true
 			}
+			request.params = nil
+			request.err = rpcErr
 		}
 	}()
-	method := service.methods[data.Method]
+	paramStr := string(data.Params)
+	if data.Method == "" || (paramStr != "" && paramStr != "null" && paramStr[0] != '[' && paramStr[0] != '{') {
+		var err any = errors.New("The method is empty or the params is neither an array nor an object.")
+		panic(err)
+	}
+	method := service.methods[methodName]
 	if method == nil {
-		errStr := "The method does not exist / is not available."
-		err := newRpcError(-32601, errStr)
-		response := rpcResponse{-1, true, err}
-		var responseErr any = newRpcResponseError(response)
-		panic(responseErr)
-	}
-	request := rpcRequest{id: data.Id, method: data.Method}
-	paramTypes := method.paramTypes
+		panicRpcError(MethodNotFoundCode, "The method does not exist / is not available.")
+	}
+	if len(method.paramNames) > 0 && len(paramStr) > 0 && paramStr[0] == '{' {
+		request.params = decodeNamedParams(method, data.Params)
+		return request
+	}
+	paramTypes := method.argTypes()
 	paramCount := len(paramTypes)
 	switch paramCount {
 	case 0:
-		errStr := fmt.Sprintln("Invalid method parameter(s).") + "method " + data.Method + "'s param count should contains receiver."
-		err := newRpcError(-32601, errStr)
-		response := rpcResponse{-1, true, err}
-		var responseErr any = newRpcResponseError(response)
-		panic(responseErr)
-	case 1:
 		if data.Params != nil {
-			errStr := fmt.Sprintln("Invalid method parameter(s).") + "method " + data.Method + "'s param count should be 0."
-			err := newRpcError(-32601, errStr)
-			response := rpcResponse{-1, true, err}
-			var responseErr any = newRpcResponseError(response)
-			panic(responseErr)
+			panicInvalidParams("method " + data.Method + "'s param count should be 0.")
 		}
 		request.params = make([]rpcParam, 0)
-	case 2:
+	case 1:
 		if data.Params == nil {
-			errStr := fmt.Sprintln("Invalid method parameter(s).") + "Param of method " + data.Method + "is empty."
-			err := newRpcError(-32601, errStr)
-			response := rpcResponse{-1, true, err}
-			var responseErr any = newRpcResponseError(response)
-			panic(responseErr)
+			panicInvalidParams("Param of method " + data.Method + "is empty.")
 		}
-		paramStr := string(data.Params)
 		isArray := len(paramStr) > 0 && paramStr[0] == '['
-		if isArray {
-			//Array is not matched
-			errStr := fmt.Sprintln("Invalid method parameter(s).") + "Param count of method" + data.Method + " is not matched."
-			err := newRpcError(-32601, errStr)
-			response := rpcResponse{-1, true, err}
-			var responseErr any = newRpcResponseError(response)
-			panic(responseErr)
+		kind := paramTypes[0].Kind()
+		if isArray && kind != reflect.Slice && kind != reflect.Array && kind != reflect.Interface {
+			//The param is sent by position.
+			paramValues := []any{reflect.New(paramTypes[0]).Interface()}
+			err := json.Unmarshal(data.Params, &paramValues)
+			if err != nil || len(paramValues) != 1 {
+				panicInvalidParams("Param count of method" + data.Method + " is not matched.")
+			}
+			request.params = []rpcParam{{paramTypes[0], reflect.ValueOf(paramValues[0]).Elem().Interface()}}
 		} else {
-			rpcParamValue := reflect.New(method.paramTypes[1]).Interface()
+			rpcParamValue := reflect.New(paramTypes[0]).Interface()
 			err := json.Unmarshal(data.Params, &rpcParamValue)
 			if err == nil {
-				request.params = []rpcParam{{method.paramTypes[1], rpcParamValue}}
+				request.params = []rpcParam{{paramTypes[0], reflect.ValueOf(rpcParamValue).Elem().Interface()}}
 			} else {
-				errStr := fmt.Sprintln("Invalid method parameter(s).") + "UnMarshal param error:" + err.Error()
-				err := newRpcError(-32601, errStr)
-				response := rpcResponse{-1, true, err}
-				var responseErr any = newRpcResponseError(response)
-				panic(responseErr)
+				panicInvalidParams("UnMarshal param error:" + err.Error())
 			}
 		}
 	default:
 		//Array
-		paramValues := make([]any, paramCount-1)
-		for i := 0; i < paramCount-1; i++ {
-			paramType := paramTypes[i+1]
+		paramValues := make([]any, paramCount)
+		for i := 0; i < paramCount; i++ {
+			paramType := paramTypes[i]
 			paramValue := reflect.New(paramType).Interface()
 			paramValues[i] = paramValue
 		}
 		err := json.Unmarshal(data.Params, &paramValues)
 		if err == nil {
-			rpcParams := make([]rpcParam, paramCount-1)
-			for i := 0; i < paramCount-1; i++ {
-				rpcParams[i] = rpcParam{method.paramTypes[i+1], reflect.ValueOf(paramValues[i]).Elem().Interface()}
+			rpcParams := make([]rpcParam, paramCount)
+			for i := 0; i < paramCount; i++ {
+				rpcParams[i] = rpcParam{paramTypes[i], reflect.ValueOf(paramValues[i]).Elem().Interface()}
 			}
 			request.params = rpcParams
 		} else {
-			errStr := fmt.Sprintln("Invalid method parameter(s).") + "UnMarshal param error:" + err.Error()
-			err := newRpcError(-32601, errStr)
-			response := rpcResponse{-1, true, err}
-			var responseErr any = newRpcResponseError(response)
-			panic(responseErr)
+			panicInvalidParams("UnMarshal param error:" + err.Error())
 		}
 	}
 	return request
 }
 
-func decodeRequestString(service *rpcService, jsonStr string) []rpcRequest {
-	defer func() {
-		var p = any(recover())
-		if p != nil {
-			responseErr, ok := p.(*RpcResponseError)
-			if ok {
-				panic(any(responseErr))
-			} else {
-				errStr := fmt.Sprintln("Invalid JSON was received by the server. An error occurred on the server while parsing the JSON text.") + fmt.Sprintf("%v", p)
-				err := newRpcError(-32700, errStr)
-				response := rpcResponse{-1, true, err}
-				var responseErr any = newRpcResponseError(response)
-				panic(responseErr)
-			}
+// decodeNamedParams Bind the by-name params onto the positional params of the method.
+func decodeNamedParams(method *rpcMethod, params json.RawMessage) []rpcParam {
+	var namedParams map[string]json.RawMessage
+	err := json.Unmarshal(params, &namedParams)
+	if err != nil {
+		panicInvalidParams("UnMarshal param error:" + err.Error())
+	}
+	paramTypes := method.argTypes()
+	indexes := make(map[string]int, len(method.paramNames))
+	for i, name := range method.paramNames {
+		indexes[name] = i
+	}
+	for name := range namedParams {
+		if _, ok := indexes[name]; !ok {
+			panicInvalidParams("Unknown param " + name + " of method " + method.name + ".")
 		}
-	}()
+	}
+	rpcParams := make([]rpcParam, len(paramTypes))
+	for i, name := range method.paramNames {
+		paramData, ok := namedParams[name]
+		if !ok {
+			panicInvalidParams("Missing param " + name + " of method " + method.name + ".")
+		}
+		paramValue := reflect.New(paramTypes[i])
+		err = json.Unmarshal(paramData, paramValue.Interface())
+		if err != nil {
+			panicInvalidParams("UnMarshal param " + name + " error:" + err.Error())
+		}
+		rpcParams[i] = rpcParam{paramTypes[i], paramValue.Elem().Interface()}
+	}
+	return rpcParams
+}
+
+// panicInvalidParams Panic with the invalid params error.
+func panicInvalidParams(errStr string) {
+	panicRpcError(InvalidParamsCode, fmt.Sprintln("Invalid method parameter(s).")+errStr)
+}
+
+// panicRpcError Panic with the RpcError, decodeRequest stores it into the request.
+func panicRpcError(code int, errStr string) {
+	var err any = NewRpcError(code, errStr, nil)
+	panic(err)
+}
+
+// rpcServiceResolver Get the service which the request of the method is dispatched to.
+type rpcServiceResolver func(method string) *rpcService
+
+// decodeRequestData Decode one entry of the request string, the numeric id is kept as json.Number.
+func decodeRequestData(resolve rpcServiceResolver, data []byte) rpcRequest {
+	var requestData requestData
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err := decoder.Decode(&requestData)
+	if err != nil {
+		errStr := fmt.Sprintln("The JSON sent is not a valid Request object.") + err.Error()
+		return rpcRequest{id: decodeRequestId(data), err: NewRpcError(InvalidRequestCode, errStr, nil), invalid: true}
+	}
+	return decodeRequest(resolve(requestData.Method), requestData)
+}
+
+// decodeRequestId Decode only the id of the request whose other members are invalid, so the error response still
+// carries the id which the client waits for.
+func decodeRequestId(data []byte) any {
+	var idData struct {
+		Id any `json:"id"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	_ = decoder.Decode(&idData)
+	return idData.Id
+}
+
+// peekRequest Get the method and id of the request or the first request in a batch.
+func peekRequest(jsonStr string) (string, any, error) {
+	jsonStr = strings.TrimSpace(jsonStr)
+	decoder := json.NewDecoder(strings.NewReader(jsonStr))
+	decoder.UseNumber()
+	var data requestData
+	if len(jsonStr) > 0 && jsonStr[0] == '[' {
+		var requestsData []json.RawMessage
+		err := json.Unmarshal([]byte(jsonStr), &requestsData)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(requestsData) == 0 {
+			return "", nil, nil
+		}
+		decoder = json.NewDecoder(bytes.NewReader(requestsData[0]))
+		decoder.UseNumber()
+	}
+	err := decoder.Decode(&data)
+	if err != nil {
+		return "", nil, err
+	}
+	return data.Method, data.Id, nil
+}
+
+// decodeRequestString Decode the request string into requests, returns whether the requests were sent in a batch.
+func decodeRequestString(service *rpcService, jsonStr string) ([]rpcRequest, bool) {
+	return decodeResolvedRequestString(func(method string) *rpcService {
+		return service
+	}, jsonStr)
+}
+
+// decodeResolvedRequestString Decode the request string into requests whose services are resolved one by one,
+// returns whether the requests were sent in a batch.
+func decodeResolvedRequestString(resolve rpcServiceResolver, jsonStr string) ([]rpcRequest, bool) {
+	jsonStr = strings.TrimSpace(jsonStr)
 	isArray := len(jsonStr) > 0 && jsonStr[0] == '['
 	if isArray {
-		var requestsData []requestData
+		var requestsData []json.RawMessage
 		err := json.Unmarshal([]byte(jsonStr), &requestsData)
-		if err == nil {
-			requests := make([]rpcRequest, len(requestsData))
-			for i := 0; i < len(requestsData); i++ {
-				requests[i] = decodeRequest(service, requestsData[i])
-			}
-			return requests
-		} else {
-			panic(any(err))
+		if err != nil {
+			errStr := fmt.Sprintln("Invalid JSON was received by the server. An error occurred on the server while parsing the JSON text.") + err.Error()
+			panic(any(newRpcResponseError(rpcResponse{nil, true, newRpcError(ParseErrorCode, errStr)})))
 		}
-
+		if len(requestsData) == 0 {
+			errStr := fmt.Sprintln("The JSON sent is not a valid Request object.") + "The batch is empty."
+			panic(any(newRpcResponseError(rpcResponse{nil, true, newRpcError(InvalidRequestCode, errStr)})))
+		}
+		requests := make([]rpcRequest, len(requestsData))
+		for i := 0; i < len(requestsData); i++ {
+			requests[i] = decodeRequestData(resolve, requestsData[i])
+		}
+		return requests, true
 	} else {
-		var requestData = new(requestData)
-		err := json.Unmarshal([]byte(jsonStr), &requestData)
-		if err == nil {
-			request := decodeRequest(service, *requestData)
-			return []rpcRequest{request}
-		} else {
-			panic(any(err))
+		if !json.Valid([]byte(jsonStr)) {
+			errStr := fmt.Sprintln("Invalid JSON was received by the server. An error occurred on the server while parsing the JSON text.") + "The request is not a valid JSON text."
+			panic(any(newRpcResponseError(rpcResponse{nil, true, newRpcError(ParseErrorCode, errStr)})))
 		}
+		request := decodeRequestData(resolve, []byte(jsonStr))
+		return []rpcRequest{request}, false
 	}
-	return nil
 }
 
 func createResponseData(response rpcResponse) map[string]any {
@@ -167,11 +376,12 @@ func createResponseData(response rpcResponse) map[string]any {
 	return result
 }
 
-func encodeResponses(responses []rpcResponse) []byte {
+// encodeResponses Encode the responses, a batch is always encoded as an array even if there is only one response.
+func encodeResponses(responses []rpcResponse, isBatch bool) []byte {
 	if len(responses) == 0 {
 		return nil
 	}
-	if len(responses) == 1 {
+	if len(responses) == 1 && !isBatch {
 		response := responses[0]
 		result := createResponseData(response)
 		buffer, err := json.Marshal(result)
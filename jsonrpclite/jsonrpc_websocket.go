@@ -0,0 +1,627 @@
+package jsonrpclite
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	webSocketGuid           = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11" //The GUID for computing Sec-WebSocket-Accept
+	webSocketMaxMessageSize = 64 << 20                               //The max size of a message
+	webSocketCloseTimeout   = time.Second                            //The time for writing the close frame
+)
+
+const (
+	webSocketContinuationFrame = 0x0
+	webSocketTextFrame         = 0x1
+	webSocketBinaryFrame       = 0x2
+	webSocketCloseFrame        = 0x8
+	webSocketPingFrame         = 0x9
+	webSocketPongFrame         = 0xA
+)
+
+// A websocket connection implemented with RFC 6455 framing.
+type rpcWebSocketConn struct {
+	conn        net.Conn
+	reader      *bufio.Reader
+	isClient    bool //The client masks the frames it sends
+	writeLocker *sync.Mutex
+}
+
+// readFrame Read a frame and unmask the payload.
+func (webSocket *rpcWebSocketConn) readFrame() (bool, byte, []byte, error) {
+	header := make([]byte, 2)
+	_, err := io.ReadFull(webSocket.reader, header)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		extended := make([]byte, 2)
+		_, err = io.ReadFull(webSocket.reader, extended)
+		if err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended))
+	case 127:
+		extended := make([]byte, 8)
+		_, err = io.ReadFull(webSocket.reader, extended)
+		if err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended)
+	}
+	if length > webSocketMaxMessageSize {
+		return false, 0, nil, errors.New("The websocket frame is too large: " + strconv.FormatUint(length, 10))
+	}
+	var maskKey []byte
+	if masked {
+		maskKey = make([]byte, 4)
+		_, err = io.ReadFull(webSocket.reader, maskKey)
+		if err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(webSocket.reader, payload)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= maskKey[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+// writeFrame Write a whole frame, the payload is masked if this is the client side.
+func (webSocket *rpcWebSocketConn) writeFrame(opcode byte, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+14)
+	frame = append(frame, 0x80|opcode)
+	var maskBit byte
+	if webSocket.isClient {
+		maskBit = 0x80
+	}
+	length := len(payload)
+	switch {
+	case length < 126:
+		frame = append(frame, maskBit|byte(length))
+	case length <= 0xFFFF:
+		frame = append(frame, maskBit|126)
+		extended := make([]byte, 2)
+		binary.BigEndian.PutUint16(extended, uint16(length))
+		frame = append(frame, extended...)
+	default:
+		frame = append(frame, maskBit|127)
+		extended := make([]byte, 8)
+		binary.BigEndian.PutUint64(extended, uint64(length))
+		frame = append(frame, extended...)
+	}
+	if webSocket.isClient {
+		maskKey := make([]byte, 4)
+		_, err := rand.Read(maskKey)
+		if err != nil {
+			return err
+		}
+		frame = append(frame, maskKey...)
+		for i, b := range payload {
+			frame = append(frame, b^maskKey[i%4])
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+	webSocket.writeLocker.Lock()
+	defer webSocket.writeLocker.Unlock()
+	_, err := webSocket.conn.Write(frame)
+	return err
+}
+
+// readMessage Read a whole text or binary message, control frames are handled in place.
+func (webSocket *rpcWebSocketConn) readMessage() ([]byte, error) {
+	var message []byte
+	for {
+		fin, opcode, payload, err := webSocket.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case webSocketPingFrame:
+			err = webSocket.writeFrame(webSocketPongFrame, payload)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		case webSocketPongFrame:
+			continue
+		case webSocketCloseFrame:
+			_ = webSocket.writeFrame(webSocketCloseFrame, payload)
+			return nil, io.EOF
+		case webSocketTextFrame, webSocketBinaryFrame, webSocketContinuationFrame:
+			message = append(message, payload...)
+			if len(message) > webSocketMaxMessageSize {
+				return nil, errors.New("The websocket message is too large.")
+			}
+		default:
+			return nil, errors.New("Unknown websocket opcode: " + strconv.Itoa(int(opcode)))
+		}
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+// writeMessage Write the message as a text frame.
+func (webSocket *rpcWebSocketConn) writeMessage(message []byte) error {
+	return webSocket.writeFrame(webSocketTextFrame, message)
+}
+
+// close Send the close frame and close the connection. The deadline also ends the write which is stalled by a slow
+// peer, otherwise the close frame would wait for the write locker forever.
+func (webSocket *rpcWebSocketConn) close() error {
+	_ = webSocket.conn.SetWriteDeadline(time.Now().Add(webSocketCloseTimeout))
+	_ = webSocket.writeFrame(webSocketCloseFrame, []byte{0x03, 0xE8})
+	return webSocket.conn.Close()
+}
+
+// remoteAddr Get the address of the other side.
+func (webSocket *rpcWebSocketConn) remoteAddr() string {
+	return webSocket.conn.RemoteAddr().String()
+}
+
+// webSocketAccept Compute the Sec-WebSocket-Accept value of the key.
+func webSocketAccept(key string) string {
+	hash := sha1.Sum([]byte(key + webSocketGuid))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// headerContains Check whether the comma separated header contains the token.
+func headerContains(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, item := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgradeWebSocket Upgrade the http request to a websocket connection, the error response has been written if failed.
+func upgradeWebSocket(writer http.ResponseWriter, request *http.Request) (*rpcWebSocketConn, error) {
+	if request.Method != "GET" || !headerContains(request.Header, "Connection", "upgrade") || !headerContains(request.Header, "Upgrade", "websocket") {
+		http.Error(writer, "Not a websocket handshake.", http.StatusBadRequest)
+		return nil, errors.New("Not a websocket handshake.")
+	}
+	if request.Header.Get("Sec-WebSocket-Version") != "13" {
+		writer.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(writer, "Unsupported websocket version.", http.StatusUpgradeRequired)
+		return nil, errors.New("Unsupported websocket version.")
+	}
+	key := request.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(writer, "Missing Sec-WebSocket-Key.", http.StatusBadRequest)
+		return nil, errors.New("Missing Sec-WebSocket-Key.")
+	}
+	hijacker, ok := writer.(http.Hijacker)
+	if !ok {
+		http.Error(writer, "Websocket is not supported.", http.StatusInternalServerError)
+		return nil, errors.New("The http.ResponseWriter does not support hijacking.")
+	}
+	conn, readWriter, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Server: JsonRpcLite-Go\r\n" +
+		"Sec-WebSocket-Accept: " + webSocketAccept(key) + "\r\n\r\n"
+	_, err = conn.Write([]byte(response))
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return &rpcWebSocketConn{conn, readWriter.Reader, false, new(sync.Mutex)}, nil
+}
+
+// dialWebSocket Connect to the websocket server with the ws:// or wss:// url.
+func dialWebSocket(ctx context.Context, rawUrl string) (*rpcWebSocketConn, error) {
+	serverUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	address := serverUrl.Host
+	if serverUrl.Port() == "" {
+		if serverUrl.Scheme == "wss" {
+			address = net.JoinHostPort(serverUrl.Hostname(), "443")
+		} else {
+			address = net.JoinHostPort(serverUrl.Hostname(), "80")
+		}
+	}
+	dialer := new(net.Dialer)
+	var conn net.Conn
+	switch serverUrl.Scheme {
+	case "ws":
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	case "wss":
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: serverUrl.Hostname()}}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", address)
+	default:
+		return nil, errors.New("Unsupported websocket scheme: " + serverUrl.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	wsConn, err := handshakeWebSocket(ctx, conn, serverUrl)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return wsConn, nil
+}
+
+// handshakeWebSocket Upgrade the connection to websocket, the handshake is bounded by the deadline of the ctx or
+// defaultRequestTimeout and aborted when the ctx is cancelled.
+func handshakeWebSocket(ctx context.Context, conn net.Conn, serverUrl *url.URL) (*rpcWebSocketConn, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultRequestTimeout)
+	}
+	err := conn.SetDeadline(deadline)
+	if err != nil {
+		return nil, err
+	}
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			//Interrupt the blocking read or write of the handshake.
+			_ = conn.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+	wsConn, err := writeWebSocketHandshake(conn, serverUrl)
+	close(stop)
+	<-stopped
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = conn.SetDeadline(time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	return wsConn, nil
+}
+
+// writeWebSocketHandshake Send the upgrade request and check the response of the server.
+func writeWebSocketHandshake(conn net.Conn, serverUrl *url.URL) (*rpcWebSocketConn, error) {
+	keyData := make([]byte, 16)
+	_, err := rand.Read(keyData)
+	if err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(keyData)
+	request := "GET " + serverUrl.RequestURI() + " HTTP/1.1\r\n" +
+		"Host: " + serverUrl.Host + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"
+	_, err = conn.Write([]byte(request))
+	if err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		return nil, err
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusSwitchingProtocols || response.Header.Get("Sec-WebSocket-Accept") != webSocketAccept(key) {
+		return nil, errors.New("Websocket handshake failed: " + response.Status)
+	}
+	return &rpcWebSocketConn{conn, reader, true, new(sync.Mutex)}, nil
+}
+
+type rpcWebSocketServerHandler struct {
+	engine *rpcWebSocketServerEngine
+}
+
+func (handler *rpcWebSocketServerHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	engine := handler.engine
+	serviceName := strings.Replace(request.URL.Path, "/", "", -1)
+	if !engine.ServiceExists(serviceName) {
+		http.Error(writer, "Service "+serviceName+" does not exist.", http.StatusServiceUnavailable)
+		return
+	}
+	conn, err := upgradeWebSocket(writer, request)
+	if err != nil {
+		logger.Warning("Upgrade websocket error: " + err.Error())
+		return
+	}
+	transportInfo := &RpcTransportInfo{Engine: engine.GetName(), RemoteAddr: request.RemoteAddr, Header: request.Header}
+	peer := newBoundRpcPeer(conn, engine.RpcServerEngineCore, serviceName, transportInfo)
+	engine.rpcPeers.add(peer)
+	defer engine.rpcPeers.remove(peer)
+	peer.run()
+}
+
+// A websocket server engine, each connection is bound to the service of the url path.
+type rpcWebSocketServerEngine struct {
+	server   *http.Server
+	port     int
+	options  *rpcServerOptions //How the engine listens
+	listener net.Listener      //The listener which the server serves on
+	*rpcPeers
+	*RpcServerEngineCore
+}
+
+// GetName Get the engine name.
+func (engine *rpcWebSocketServerEngine) GetName() string {
+	return "RpcWebSocketServerEngine"
+}
+
+// Start the engine and initialize the router.
+func (engine *rpcWebSocketServerEngine) Start(router *rpcRouter) error {
+	if engine.server != nil {
+		logger.Warning("The server of engine already started, will be closed.")
+		engine.Stop()
+	}
+	listener, err := engine.options.listen("tcp", ":"+strconv.Itoa(engine.port))
+	if err != nil {
+		return errors.New("Listen for engine " + engine.GetName() + " error: " + err.Error())
+	}
+	engine.RpcServerEngineCore.SetRouter(router)
+	server := new(http.Server)
+	handler := new(rpcWebSocketServerHandler)
+	handler.engine = engine
+	server.Handler = handler
+	server.Addr = listener.Addr().String()
+	engine.server = server
+	engine.listener = listener
+	logger.Info("The engine " + engine.GetName() + " listens on " + listener.Addr().String())
+	go func() {
+		logger.Info(server.Serve(listener).Error())
+	}()
+	return nil
+}
+
+// Addr Get the address which the engine listens on, nil if it is not started.
+func (engine *rpcWebSocketServerEngine) Addr() net.Addr {
+	return listenerAddr(engine.listener)
+}
+
+// ListenerFile Get the listener which can be handed to a new process, see RestartServers.
+func (engine *rpcWebSocketServerEngine) ListenerFile() (string, *os.File, error) {
+	file, err := listenerFile(engine.listener)
+	return engine.options.key, file, err
+}
+
+// handOff Keep the socket file of the listener when it is closed, the new process serves on it.
+func (engine *rpcWebSocketServerEngine) handOff() {
+	keepSocketFile(engine.listener)
+}
+
+// Shutdown Stop listening, wait for the requests in process until the ctx is done and then close the connections.
+func (engine *rpcWebSocketServerEngine) Shutdown(ctx context.Context) error {
+	if engine.server != nil {
+		//The websocket connections are hijacked, so it returns once the listener is closed.
+		err := engine.server.Shutdown(ctx)
+		if err != nil {
+			logger.Warning("Shutdown the server of engine error: " + err.Error())
+		}
+		engine.server = nil
+		engine.listener = nil
+	}
+	err := engine.RpcServerEngineCore.Drain(ctx)
+	engine.rpcPeers.closeAll()
+	engine.RpcServerEngineCore.SetRouter(nil)
+	return err
+}
+
+// Stop the engine and free the router.
+func (engine *rpcWebSocketServerEngine) Stop() {
+	if engine.server != nil {
+		err := engine.server.Close()
+		if err != nil {
+			logger.Warning("Close the server of engine error: " + err.Error())
+		}
+		engine.server = nil
+		engine.listener = nil
+	}
+	engine.rpcPeers.closeAll()
+	engine.RpcServerEngineCore.SetRouter(nil)
+}
+
+// NewRpcWebSocketServerEngine Create a new websocket server engine, clients connect to ws://host:port/ServiceName.
+// It listens on ":port" unless the options give another address or listener.
+func NewRpcWebSocketServerEngine(port int, options ...RpcServerOption) RpcServerEngine {
+	engine := new(rpcWebSocketServerEngine)
+	engine.port = port
+	engine.options = newRpcServerOptions(options)
+	engine.rpcPeers = newRpcPeers()
+	engine.RpcServerEngineCore = new(RpcServerEngineCore)
+	return engine
+}
+
+// A websocket client engine which keeps one connection for each service, the long polling client engine shares it
+// with another dial.
+type rpcWebSocketClientEngine struct {
+	name       string
+	dial       func(ctx context.Context, serviceUrl string) (rpcMessageConn, error)
+	serverHost string
+	locker     *sync.Mutex
+	peers      map[string]*RpcPeer
+	dialing    map[string]*rpcPeerDial  //The dials in process, the callers of the same service wait for them
+	handlers   *rpcNotificationHandlers //Handle the notifications pushed on all connections
+}
+
+// A dial of the connection of a service which is in process.
+type rpcPeerDial struct {
+	done chan struct{} //Closed when the dial finishes
+	peer *RpcPeer
+	err  error
+}
+
+// GetName Get the engine name.
+func (engine *rpcWebSocketClientEngine) GetName() string {
+	return engine.name
+}
+
+// getPeer Get the connection of the service, a new one is dialed if not exists or closed.
+func (engine *rpcWebSocketClientEngine) getPeer(ctx context.Context, serviceName string) (*RpcPeer, error) {
+	for {
+		engine.locker.Lock()
+		peer := engine.peers[serviceName]
+		if peer != nil && !peer.isClosed() {
+			engine.locker.Unlock()
+			return peer, nil
+		}
+		dial := engine.dialing[serviceName]
+		if dial == nil {
+			//Dial without the lock, so a slow server does not block the other services.
+			dial = &rpcPeerDial{done: make(chan struct{})}
+			engine.dialing[serviceName] = dial
+			engine.locker.Unlock()
+			conn, err := engine.dial(ctx, engine.serverHost+"/"+serviceName)
+			engine.locker.Lock()
+			delete(engine.dialing, serviceName)
+			if err == nil {
+				dial.peer = newRpcClientPeer(conn, nil, engine.GetName(), engine.handlers)
+				engine.peers[serviceName] = dial.peer
+			}
+			dial.err = err
+			close(dial.done)
+			engine.locker.Unlock()
+			return dial.peer, dial.err
+		}
+		engine.locker.Unlock()
+		select {
+		case <-dial.done:
+			if dial.err == nil {
+				return dial.peer, nil
+			}
+			if !errors.Is(dial.err, context.Canceled) && !errors.Is(dial.err, context.DeadlineExceeded) {
+				return nil, dial.err
+			}
+			//The dial was stopped by the context of another caller, try again with this one.
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// ProcessData Send the rpc request data to the server.
+func (engine *rpcWebSocketClientEngine) ProcessData(serviceName string, method string, params []any) string {
+	requestData, err := encodeRequestData(nextRequestId(), method, params)
+	if err == nil {
+		return engine.ProcessString(serviceName, string(requestData))
+	} else {
+		var sendErr any = errors.New("Send request error: " + err.Error())
+		panic(sendErr)
+	}
+}
+
+// ProcessString Send the rpc request string to the server.
+func (engine *rpcWebSocketClientEngine) ProcessString(serviceName string, requestStr string) string {
+	response, err := engine.ProcessStringContext(context.Background(), serviceName, requestStr)
+	if err == nil {
+		return response
+	} else {
+		var sendErr any = err
+		panic(sendErr)
+	}
+}
+
+// ProcessStringContext Send the rpc request string to the server, transport failures are returned as error.
+func (engine *rpcWebSocketClientEngine) ProcessStringContext(ctx context.Context, serviceName string, requestStr string) (string, error) {
+	peer, err := engine.getPeer(ctx, serviceName)
+	if err != nil {
+		return "", errors.New("Send request error: " + err.Error())
+	}
+	return peer.call(ctx, requestStr)
+}
+
+// Close the engine and the connections.
+func (engine *rpcWebSocketClientEngine) Close() {
+	engine.locker.Lock()
+	peers := engine.peers
+	engine.peers = make(map[string]*RpcPeer)
+	engine.locker.Unlock()
+	for _, peer := range peers {
+		peer.Close()
+	}
+}
+
+// OnNotification Register the handler of the notification method pushed by the server, see RpcPeer.OnNotification.
+func (engine *rpcWebSocketClientEngine) OnNotification(method string, handler any) {
+	engine.handlers.register(method, handler)
+}
+
+// Subscribe Call the subscription method of the service, see RpcPeer.Subscribe.
+func (engine *rpcWebSocketClientEngine) Subscribe(ctx context.Context, serviceName string, method string, params []any, handler any) (string, error) {
+	return subscribe(ctx, func(ctx context.Context, requestStr string) (string, error) {
+		return engine.ProcessStringContext(ctx, serviceName, requestStr)
+	}, method, params, handler)
+}
+
+// Unsubscribe Cancel the subscription made by Subscribe.
+func (engine *rpcWebSocketClientEngine) Unsubscribe(ctx context.Context, serviceName string, subscriptionId string) error {
+	engine.handlers.removeSubscription(subscriptionId)
+	return unsubscribeRemote(ctx, func(ctx context.Context, requestStr string) (string, error) {
+		return engine.ProcessStringContext(ctx, serviceName, requestStr)
+	}, UnsubscribeMethod, subscriptionId)
+}
+
+// Stream Call the method of the service which returns a channel, see RpcPeer.Stream.
+func (engine *rpcWebSocketClientEngine) Stream(ctx context.Context, serviceName string, method string, params []any, handler any) error {
+	return streamCall(ctx, func(ctx context.Context, requestStr string) (string, error) {
+		return engine.ProcessStringContext(ctx, serviceName, requestStr)
+	}, method, params, handler)
+}
+
+// NewRpcWebSocketClientEngine Create a new websocket client engine, the serverHost looks like ws://localhost:8080.
+func NewRpcWebSocketClientEngine(serverHost string) RpcClientEngine {
+	engine := newRpcWebSocketClientEngine(serverHost)
+	engine.name = "RpcWebSocketClientEngine"
+	engine.dial = func(ctx context.Context, serviceUrl string) (rpcMessageConn, error) {
+		return dialWebSocket(ctx, serviceUrl)
+	}
+	return engine
+}
+
+// newRpcWebSocketClientEngine Create a client engine without connections.
+func newRpcWebSocketClientEngine(serverHost string) *rpcWebSocketClientEngine {
+	engine := new(rpcWebSocketClientEngine)
+	engine.serverHost = serverHost
+	engine.locker = new(sync.Mutex)
+	engine.peers = make(map[string]*RpcPeer)
+	engine.dialing = make(map[string]*rpcPeerDial)
+	engine.handlers = newRpcNotificationHandlers()
+	return engine
+}
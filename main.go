@@ -37,7 +37,11 @@ func main() {
 	router.RegisterService("ITest", serviceInstance)
 	serverEngine := jsonrpclite.NewRpcHttpServerEngine(8080)
 	server := jsonrpclite.NewRpcServer(serverEngine)
-	server.Start(router)
+	err := server.Start(router)
+	if err != nil {
+		fmt.Println("Rpc server failed to start: " + err.Error())
+		return
+	}
 	fmt.Println("Rpc server started.")
 
 	clientEngine := jsonrpclite.NewRpcHttpClientEngine("http://localhost:8080")